@@ -33,7 +33,14 @@ func (app *application) refillItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	item, err := app.items.GetItem(input.ItemID)
+	tx, err := app.additions.DB.Begin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	item, err := app.items.AddRemaining(tx, input.ItemID, input.Quantity)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecord):
@@ -44,25 +51,35 @@ func (app *application) refillItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx, err := app.additions.DB.Begin()
+	addition := &data.Addition{
+		ItemID:    input.ItemID,
+		Quantity:  input.Quantity,
+		Remarks:   input.Remarks,
+		CreatedBy: app.contextGetUserID(r),
+	}
+
+	err = app.additions.InsertAddition(tx, addition)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	addition := &data.Addition{
-		ItemID:   input.ItemID,
-		Quantity: input.Quantity,
-		Remarks:  input.Remarks,
+	before := *item
+	before.Remaining -= addition.Quantity
+
+	err = app.recordItemChange(tx, r, "added", &before, item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.additions.InsertAddition(tx, addition)
+	err = app.recordEvent(tx, "addition.created", addition.ItemID, envelope{"addition": addition})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.items.AddRemaining(tx, input.ItemID, input.Quantity, item.Version)
+	err = app.recordAudit(tx, r)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -83,45 +100,15 @@ func (app *application) refillItem(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) listRefills(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIdFromParams(r)
-	if err != nil {
+	if err != nil || id < 1 {
 		app.notFoundErrorResponse(w, r)
 		return
 	}
 
-	v := validator.New()
-
-	qs := r.URL.Query()
-	var input struct {
-		ItemID int64
-		data.Filters
-	}
-	input.ItemID = id
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
-	input.Filters.Sort = app.readString(qs, "sort", "-added_at")
-	input.Filters.SortSafelist = []string{"id", "-id", "added_at", "-added_at"}
-
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	v.Check(input.ItemID != 0, "item_id", "Field cannot be blank")
-	v.Check(input.ItemID > 0, "item_id", "Field cannot be negative")
-
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	additions, metadata, err := app.additions.GetAdditions(id, input.Filters)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
+	app.listLedger(w, r, id, app.additionList("-added_at"))
+}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"additions": additions, "metadata": metadata}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-	}
+// Lists additions across all items, each with the name of its item
+func (app *application) listAllRefills(w http.ResponseWriter, r *http.Request) {
+	app.listLedger(w, r, 0, app.additionList("-added_at"))
 }
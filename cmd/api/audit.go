@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"test.com/internal/data"
+	"test.com/internal/validator"
+)
+
+// auditChange is filled in by a handler with what its request changed. When a
+// handler leaves it empty the request path and body are recorded instead.
+type auditChange struct {
+	action string
+	body   []byte
+	target string
+	diff   interface{}
+	// recorded is set once the handler wrote the entry in its transaction
+	recorded bool
+}
+
+// Tags every request with an ID of its own, generated here so that it cannot
+// be forged, and sends it back in X-Request-ID. Any X-Request-ID the client
+// sent is recorded apart from it by the audit log.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		id := hex.EncodeToString(b)
+
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// Returns the X-Request-ID the client sent, or "" when it sent none or one
+// longer than 128 bytes
+func clientRequestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if len(id) > 128 {
+		return ""
+	}
+	return id
+}
+
+// Records a successful write in the audit log. Handlers write the entry in the
+// transaction of their change with recordAudit, so that the change is not kept
+// without it. A request that changed nothing, such as a password reset for an
+// unknown address, has its entry written once next has handled it, with the
+// response held back until then: when the entry cannot be written the client
+// gets a 500 rather than a success that the audit log does not show.
+func (app *application) audit(action string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		change := &auditChange{action: action, body: body}
+		r = r.WithContext(context.WithValue(r.Context(), auditContextKey, change))
+
+		header := w.Header().Clone()
+		buf := &responseBuffer{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		if buf.status >= http.StatusBadRequest || change.recorded {
+			buf.flush()
+			return
+		}
+
+		entry, err := app.auditEntry(r, change)
+		if err == nil {
+			err = app.audits.Insert(entry)
+		}
+		if err != nil {
+			for key := range w.Header() {
+				delete(w.Header(), key)
+			}
+			for key, value := range header {
+				w.Header()[key] = value
+			}
+
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		buf.flush()
+	})
+}
+
+// Writes the audit log entry of the request as part of tx, so that it commits
+// or rolls back together with the change. It does nothing on routes that are
+// not audited.
+func (app *application) recordAudit(tx *sql.Tx, r *http.Request) error {
+	change, ok := r.Context().Value(auditContextKey).(*auditChange)
+	if !ok {
+		return nil
+	}
+
+	entry, err := app.auditEntry(r, change)
+	if err != nil {
+		return err
+	}
+
+	err = app.audits.InsertTx(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	change.recorded = true
+	return nil
+}
+
+// Returns the audit log entry for the change a request made
+func (app *application) auditEntry(r *http.Request, change *auditChange) (*data.AuditEntry, error) {
+	entry := &data.AuditEntry{
+		ActorID:         app.contextGetUserID(r),
+		APIKeyID:        app.contextGetUser(r).APIKeyID,
+		Action:          change.action,
+		Target:          change.target,
+		RequestID:       app.contextGetRequestID(r),
+		ClientRequestID: clientRequestID(r),
+		IP:              clientIP(r),
+	}
+
+	if entry.Target == "" {
+		entry.Target = r.URL.Path
+	}
+
+	var err error
+	if change.diff != nil {
+		entry.Diff, err = json.Marshal(change.diff)
+	} else {
+		entry.Diff, err = auditRequestBody(change.body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Describes the change a request made for its audit log entry. It does nothing
+// on routes that are not audited.
+func (app *application) auditChange(r *http.Request, target string, diff interface{}) {
+	change, ok := r.Context().Value(auditContextKey).(*auditChange)
+	if !ok {
+		return
+	}
+
+	change.target = target
+	change.diff = diff
+}
+
+// Returns the JSON request body for the audit log, without any passwords or
+// tokens
+func auditRequestBody(body []byte) (json.RawMessage, error) {
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) != nil {
+		return nil, nil
+	}
+
+	for key := range fields {
+		if key == "password" || key == "new_password" || key == "current_password" || key == "token" || key == "refresh_token" {
+			delete(fields, key)
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+func (app *application) listAudit(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.AuditFilter
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+	input.Action = app.readString(qs, "action", "")
+	input.From = app.readTime(qs, "from", false, v)
+	input.To = app.readTime(qs, "to", true, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "-id", "created_at", "-created_at"}
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.IncludeTotal = app.readBool(qs, "include_total", false, v)
+
+	data.ValidateAuditFilter(v, input.AuditFilter)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.audits.GetAll(input.AuditFilter, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
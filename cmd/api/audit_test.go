@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"test.com/internal/data"
+	"test.com/internal/jsonlog"
+)
+
+func TestAuditFailsClosed(t *testing.T) {
+	// A closed database fails every insert without needing a server
+	db, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	app := &application{
+		audits: &data.AuditModel{DB: db},
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+	}
+
+	handler := app.audit("test.create", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/tests/1")
+		app.writeJSON(w, http.StatusCreated, envelope{"test": "created"}, nil)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/tests", strings.NewReader(`{}`))
+	r = app.contextSetUser(r, data.AnonymousUser)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(w.Body.String(), "created") {
+		t.Errorf("the response of the unaudited change was sent: %s", w.Body.String())
+	}
+	if w.Header().Get("Location") != "" {
+		t.Errorf("the headers of the unaudited change were sent")
+	}
+}
+
+func TestRequestIDIsGenerated(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelOff)}
+
+	var id string
+	handler := app.requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = app.contextGetRequestID(r)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Request-ID", "forged")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, r)
+
+	if id == "forged" || len(id) != 32 {
+		t.Errorf("request ID = %q; want one generated by the server", id)
+	}
+	if got := w.Header().Get("X-Request-ID"); got != id {
+		t.Errorf("X-Request-ID = %q; want %q", got, id)
+	}
+	if got := clientRequestID(r); got != "forged" {
+		t.Errorf("client request ID = %q; want %q", got, "forged")
+	}
+}
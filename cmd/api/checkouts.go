@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"test.com/internal/data"
+	"test.com/internal/validator"
+)
+
+// Issues several items to one recipient in a single transaction, so either
+// every line is issued or none is
+func (app *application) addCheckout(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IssuedTo string     `json:"issued_to"`
+		DueAt    *time.Time `json:"due_at"`
+		Lines    []struct {
+			ItemID   int64 `json:"item_id"`
+			Quantity int32 `json:"quantity"`
+		} `json:"lines"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.IssuedTo != "", "issued_to", "must be provided")
+	v.Check(input.DueAt == nil || input.DueAt.After(time.Now()), "due_at", "must be in the future")
+	v.Check(len(input.Lines) > 0, "lines", "must contain at least one line")
+	v.Check(len(input.Lines) <= 100, "lines", "must not contain more than 100 lines")
+
+	seen := make(map[int64]bool)
+	for i, line := range input.Lines {
+		key := fmt.Sprintf("lines[%d]", i)
+		v.Check(line.ItemID > 0, key+".item_id", "must be greater than 0")
+		v.Check(line.Quantity > 0, key+".quantity", "must be greater than 0")
+		v.Check(!seen[line.ItemID], key+".item_id", "item already appears in another line")
+		seen[line.ItemID] = true
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tx, err := app.items.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Items are locked in ID order so that concurrent checkouts cannot deadlock
+	order := make([]int, len(input.Lines))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return input.Lines[order[a]].ItemID < input.Lines[order[b]].ItemID
+	})
+
+	// Every line is attempted so that all of the problems are reported at once
+	issues := make([]*data.Issue, len(input.Lines))
+	for _, i := range order {
+		line := input.Lines[i]
+		key := fmt.Sprintf("lines[%d]", i)
+
+		item, err := app.items.UpdateRemaining(tx, line.ItemID, line.Quantity)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrNoRecord):
+				v.AddError(key+".item_id", "does not exist")
+			case errors.Is(err, data.ErrInsufficientStock) && item.Remaining == 0:
+				v.AddError(key+".quantity", "item is not available")
+			case errors.Is(err, data.ErrInsufficientStock):
+				v.AddError(key+".quantity", "item is not available in the required quantity")
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			continue
+		}
+
+		issue := &data.Issue{
+			ItemID:    line.ItemID,
+			ItemName:  item.Name,
+			Quantity:  line.Quantity,
+			IssuedTo:  input.IssuedTo,
+			CreatedBy: app.contextGetUserID(r),
+			DueAt:     input.DueAt,
+		}
+
+		err = app.issues.InsertIssue(tx, issue)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		before := *item
+		before.Remaining += line.Quantity
+
+		err = app.recordItemChange(tx, r, "issued", &before, item)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.recordEvent(tx, "issue.created", issue.ItemID, envelope{"issue": issue})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.recordLowStock(tx, &before, item)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		issues[i] = issue
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	checkout := envelope{"issued_to": input.IssuedTo, "issues": issues}
+
+	app.auditChange(r, "checkout", checkout)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"checkout": checkout}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
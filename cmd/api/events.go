@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"test.com/internal/data"
+	"test.com/internal/validator"
+)
+
+const (
+	eventCatchUpLimit      = 1000
+	eventSubscriberBuffer  = 64
+	eventHeartbeatInterval = 15 * time.Second
+	eventRecheckInterval   = time.Minute
+)
+
+// streamEvent is a change sent to the clients of GET /events.
+type streamEvent struct {
+	ID     int64
+	Type   string
+	ItemID int64
+	Tags   []string
+	Data   []byte
+}
+
+// eventBroker fans the events of this process out to its connected streams.
+// Events are numbered by their outbox position, in the order they committed.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan streamEvent]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[chan streamEvent]struct{})}
+}
+
+// publish passes the event to every subscriber. A subscriber
+// that is too far behind to take it is dropped, and can catch up from the
+// outbox when it reconnects.
+func (b *eventBroker) publish(event streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *eventBroker) subscribe() chan streamEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan streamEvent, eventSubscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// closeAll ends every open stream, so that they do not hold up a shutdown.
+func (b *eventBroker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// streamFilter limits a stream to an item and/or items with any of the tags.
+type streamFilter struct {
+	itemID int64
+	tags   []string
+}
+
+func (f streamFilter) matches(event streamEvent) bool {
+	if f.itemID != 0 && event.ItemID != f.itemID {
+		return false
+	}
+
+	if len(f.tags) == 0 {
+		return true
+	}
+
+	for _, tag := range f.tags {
+		for _, itemTag := range event.Tags {
+			if strings.EqualFold(tag, itemTag) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Streams item, issue, removal and addition changes as Server-Sent Events. A
+// client reconnecting with Last-Event-ID is sent the events it missed, or a
+// reset event when it missed more than eventCatchUpLimit of them or some of
+// them have been removed from the outbox.
+func (app *application) streamEvents(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filter := streamFilter{
+		itemID: int64(app.readInt(qs, "item_id", 0, v)),
+		tags:   qs["tag"],
+	}
+
+	lastID := int64(0)
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		v.Check(err == nil && id >= 0, "Last-Event-ID", "must be an event ID")
+		lastID = id
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The stream is long-lived, so the server's write timeout must not apply
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	ch := app.events.subscribe()
+	defer app.events.unsubscribe(ch)
+
+	// Catch up from the outbox, which any replica can read. Events committed
+	// while catching up may come through the subscription as well, and are
+	// skipped as they are not after lastID.
+	if lastID != 0 {
+		latest, pruned, err := app.outbox.Positions()
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		var missed []*data.OutboxEvent
+		if lastID >= pruned {
+			missed, err = app.outbox.GetAfter(lastID, eventCatchUpLimit+1)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+		}
+
+		// Rather than leave a gap, tell the client to fetch the current state
+		// again and carry on from the newest event
+		switch {
+		case lastID < pruned:
+			writeStreamReset(w, latest, "missed events have been removed, fetch the current state again")
+			lastID = latest
+		case len(missed) > eventCatchUpLimit:
+			writeStreamReset(w, latest, "too many missed events, fetch the current state again")
+			lastID = latest
+			missed = nil
+		}
+
+		for _, e := range missed {
+			event, err := newStreamEvent(e)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+
+			lastID = event.ID
+			if filter.matches(event) {
+				writeStreamEvent(w, event)
+			}
+		}
+	}
+	rc.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	recheck := time.NewTicker(eventRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event.ID <= lastID {
+				continue
+			}
+			lastID = event.ID
+			if filter.matches(event) {
+				writeStreamEvent(w, event)
+			}
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+
+		case <-recheck.C:
+			allowed, err := app.streamAllowed(r)
+			if err != nil {
+				app.logError(r, err)
+				return
+			}
+			if !allowed {
+				return
+			}
+		}
+
+		err := rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Reports whether the client of a stream may still read it. The session or API
+// key, or the read permission, may be revoked while the stream is open.
+func (app *application) streamAllowed(r *http.Request) (bool, error) {
+	user := app.contextGetUser(r)
+
+	if user.APIKeyID == nil {
+		var err error
+		user, err = app.users.GetForToken(data.ScopeAuthentication, app.contextGetToken(r))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrNoRecord):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+
+		if user.IsAdmin {
+			return true, nil
+		}
+	}
+
+	permissions, err := app.currentPermissions(user)
+	if err != nil {
+		return false, err
+	}
+
+	return permissions.Include("read"), nil
+}
+
+func newStreamEvent(e *data.OutboxEvent) (streamEvent, error) {
+	body, err := e.Message()
+	if err != nil {
+		return streamEvent{}, err
+	}
+
+	return streamEvent{ID: e.Position, Type: e.Event, ItemID: e.ItemID, Tags: e.Tags, Data: body}, nil
+}
+
+// Publishes every event saved to the outbox, by any replica, to the streams of
+// this process in the order they committed, until ctx is cancelled. Events
+// are announced on the outbox channel as their transactions commit, which
+// wakes the listener to sequence them and read on from the last it published.
+// It also reads on every heartbeat, so that events announced while it was
+// reconnecting are published once it is back.
+func (app *application) runEventListener(ctx context.Context) {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+	defer listener.Close()
+
+	err := listener.Listen(data.OutboxChannel)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	// Publishing starts after the newest event sequenced when the listener
+	// started
+	err = app.outbox.Sequence()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	lastPosition, _, err := app.outbox.Positions()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	// Sequences the committed events and publishes those after lastPosition, a
+	// page at a time
+	catchUp := func() {
+		err := app.outbox.Sequence()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		for {
+			events, err := app.outbox.GetAfter(lastPosition, eventCatchUpLimit)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				return
+			}
+
+			for _, e := range events {
+				lastPosition = e.Position
+
+				event, err := newStreamEvent(e)
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"outbox_id": strconv.FormatInt(e.ID, 10)})
+					continue
+				}
+
+				app.events.publish(event)
+			}
+
+			if len(events) < eventCatchUpLimit {
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-listener.Notify:
+			// One read covers every event announced so far
+			for len(listener.Notify) > 0 {
+				<-listener.Notify
+			}
+			catchUp()
+
+		case <-time.After(eventHeartbeatInterval):
+			go listener.Ping()
+			catchUp()
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, event streamEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+}
+
+// Tells the client that it cannot be sent the events it missed, and moves its
+// Last-Event-ID on to id
+func writeStreamReset(w http.ResponseWriter, id int64, reason string) {
+	body, _ := json.Marshal(map[string]string{"reason": reason})
+	fmt.Fprintf(w, "id: %d\nevent: reset\ndata: %s\n\n", id, body)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+
+	"test.com/internal/data"
+	"test.com/internal/validator"
+)
+
+// Lists items expected to run out within the given number of days, soonest first
+func (app *application) listRunningOut(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Window int
+		Within int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Window = app.readInt(qs, "window", app.config.forecast.window, v)
+	input.Within = app.readInt(qs, "within", 14, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
+	input.Filters.Sort = "days_until_stockout"
+	input.Filters.SortSafelist = []string{"days_until_stockout"}
+
+	data.ValidateForecastWindow(v, input.Window)
+	v.Check(input.Within >= 0, "within", "must not be negative")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	forecasts, metadata, err := app.forecasts.GetRunningOut(input.Window, input.Within, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"forecasts": forecasts, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"test.com/internal/data"
 	"test.com/internal/validator"
 )
 
@@ -92,6 +96,66 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// Return the entity tag of an item, which changes with every version
+func itemETag(item *data.Item) string {
+	return fmt.Sprintf(`"%d-%d"`, item.ID, item.Version)
+}
+
+// Return the entity tag of an item sent together with its forecast, which
+// changes with the forecast as well as with the version of the item
+func forecastETag(item *data.Item, forecast *data.Forecast) (string, error) {
+	js, err := json.Marshal(forecast)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+	return fmt.Sprintf(`"%d-%d-%x"`, item.ID, item.Version, sum[:8]), nil
+}
+
+// Report whether an If-Match header lists the current version of item, in
+// any of its representations
+func itemETagMatches(header string, item *data.Item) bool {
+	etag := itemETag(item)
+	withForecast := strings.TrimSuffix(etag, `"`) + "-"
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" || tag == etag || strings.HasPrefix(tag, withForecast) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Report whether an If-Match or If-None-Match header lists etag. If-None-Match
+// uses weak comparison, where W/ prefixed tags match as well.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return the IP address of the client, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // Return key's value string from query, or the default value
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
@@ -115,3 +179,125 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	}
 	return i
 }
+
+// Return key's value bool from query, or the default value
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+	return b
+}
+
+// Return the time zone named by the tz query parameter, UTC by default, or nil
+// if it is not a valid one
+func (app *application) readLocation(qs url.Values, v *validator.Validator) *time.Location {
+	tz := qs.Get("tz")
+	if tz == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		v.AddError("tz", "must be a valid IANA time zone")
+		return nil
+	}
+	return loc
+}
+
+// Return key's value time from query, or the zero time.
+//
+// Plain dates (2006-01-02) are read in the tz time zone, UTC by default, as the
+// start of that day, or as its last instant when endOfDay is set.
+func (app *application) readTime(qs url.Values, key string, endOfDay bool, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return time.Time{}
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+
+	loc := app.readLocation(qs, v)
+	if loc == nil {
+		return time.Time{}
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", s, loc)
+	if err != nil {
+		v.AddError(key, "must be a date (YYYY-MM-DD) or an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	if endOfDay {
+		// Postgres stores microseconds, so this is the last instant of the day
+		return day.AddDate(0, 0, 1).Add(-time.Microsecond)
+	}
+	return day
+}
+
+// Return the point in time requested by the as_of query parameter, or the zero
+// time if it was not provided. A date means the end of that day.
+func (app *application) readAsOf(qs url.Values, v *validator.Validator) time.Time {
+	t := app.readTime(qs, "as_of", true, v)
+	if t.IsZero() {
+		return t
+	}
+
+	now := time.Now()
+
+	// Today has not ended yet, so its stock is the current stock
+	isDate := len(qs.Get("as_of")) == len("2006-01-02")
+	if isDate && t.After(now) && t.AddDate(0, 0, -1).Before(now) {
+		return now
+	}
+
+	v.Check(!t.After(now), "as_of", "must not be in the future")
+	return t
+}
+
+// Return the parsed filter expression from the query, checked against the
+// fields the resource exposes, or nil if it was not provided. Dates in it are
+// read in the tz time zone, UTC by default.
+func (app *application) readExpression(qs url.Values, fields data.FilterFields, v *validator.Validator) *data.Expression {
+	s := qs.Get("filter")
+	if s == "" {
+		return nil
+	}
+
+	loc := app.readLocation(qs, v)
+	if loc == nil {
+		return nil
+	}
+
+	expression, err := data.ParseExpression(s, fields, loc)
+	if err != nil {
+		v.AddError("filter", err.Error())
+		return nil
+	}
+	return expression
+}
+
+// Runs fn in a goroutine that is waited for on shutdown, recovering any panic
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"test.com/internal/data"
 	"test.com/internal/validator"
@@ -10,9 +12,10 @@ import (
 
 func (app *application) addIssue(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		ItemID   int64  `json:"item_id"`
-		Quantity int32  `json:"quantity"`
-		IssuedTo string `json:"issued_to"`
+		ItemID   int64      `json:"item_id"`
+		Quantity int32      `json:"quantity"`
+		IssuedTo string     `json:"issued_to"`
+		DueAt    *time.Time `json:"due_at"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -26,6 +29,7 @@ func (app *application) addIssue(w http.ResponseWriter, r *http.Request) {
 	validator.Check(input.ItemID > 0, "item_id", "Field cannot be negative")
 	validator.Check(input.Quantity > 0, "quantity", "Field must be positive integer")
 	validator.Check(input.IssuedTo != "", "issued_to", "Field cannot be blank")
+	validator.Check(input.DueAt == nil || input.DueAt.After(time.Now()), "due_at", "must be in the future")
 
 	if !validator.Valid() {
 		app.failedValidationResponse(w, r, validator.Errors)
@@ -33,52 +37,64 @@ func (app *application) addIssue(w http.ResponseWriter, r *http.Request) {
 	}
 
 	issue := &data.Issue{
-		ItemID:   input.ItemID,
-		Quantity: input.Quantity,
-		IssuedTo: input.IssuedTo,
+		ItemID:    input.ItemID,
+		Quantity:  input.Quantity,
+		IssuedTo:  input.IssuedTo,
+		CreatedBy: app.contextGetUserID(r),
+		DueAt:     input.DueAt,
 	}
 
-	item, err := app.items.GetItem(issue.ItemID)
+	// Begin transaction to issue and update item
+	tx, err := app.items.DB.Begin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	item, err := app.items.UpdateRemaining(tx, issue.ItemID, issue.Quantity)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecord):
 			app.notFoundErrorResponse(w, r)
+		case errors.Is(err, data.ErrInsufficientStock):
+			app.insufficientStockResponse(w, r, item)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	if item.Remaining == 0 {
-		app.failedValidationResponse(w, r, map[string]string{"item": "item is not available"})
+	err = app.issues.InsertIssue(tx, issue)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
-	} else if item.Remaining < issue.Quantity {
-		app.failedValidationResponse(w, r, map[string]string{"item": "item is not available in the required quantity"})
+	}
+
+	before := *item
+	before.Remaining += issue.Quantity
+
+	err = app.recordItemChange(tx, r, "issued", &before, item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// Begin transaction to issue and update item
-	tx, err := app.items.DB.Begin()
+	err = app.recordEvent(tx, "issue.created", issue.ItemID, envelope{"issue": issue})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	defer tx.Rollback()
 
-	err = app.issues.InsertIssue(tx, issue)
+	err = app.recordLowStock(tx, &before, item)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.items.UpdateRemaining(tx, issue.ItemID, issue.Quantity, item.Version)
+	err = app.recordAudit(tx, r)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
@@ -94,54 +110,111 @@ func (app *application) addIssue(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Always sorted by issued_at
-func (app *application) listIssues(w http.ResponseWriter, r *http.Request) {
+// Marks a loan as returned and puts its stock back, as an adjustment so that
+// the stock at earlier times is still known
+func (app *application) returnIssue(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IssueID int64 `json:"issue_id"`
+	}
 
-	id, err := app.readIdFromParams(r)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.notFoundErrorResponse(w, r)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
 	v := validator.New()
+	v.Check(input.IssueID > 0, "issue_id", "must be greater than 0")
 
-	qs := r.URL.Query()
-	var input struct {
-		ItemID int64
-		data.Filters
-	}
-	input.ItemID = id
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
-	input.Filters.Sort = app.readString(qs, "sort", "-issued_at")
-	input.Filters.SortSafelist = []string{"id", "-id", "issued_at", "-issued_at"}
-
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	v.Check(input.ItemID != 0, "item_id", "Field cannot be blank")
-	v.Check(input.ItemID > 0, "item_id", "Field cannot be negative")
-
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	tx, err := app.issues.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
+	defer tx.Rollback()
 
-	issues, metadata, err := app.issues.GetIssues(input.ItemID, input.Filters)
+	issue, err := app.issues.Return(tx, input.IssueID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecord):
 			app.notFoundErrorResponse(w, r)
+		case errors.Is(err, data.ErrNotOnLoan):
+			v.AddError("issue_id", "issue is not a loan or was already returned")
+			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"issues": issues, "metadata": metadata}, nil)
+	item, err := app.items.AddRemaining(tx, issue.ItemID, issue.Quantity)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	adjustment := &data.Adjustment{
+		ItemID:   issue.ItemID,
+		Quantity: issue.Quantity,
+		Remarks:  fmt.Sprintf("issue %d returned", issue.ID),
+	}
+
+	err = app.adjustments.InsertAdjustment(tx, adjustment)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	before := *item
+	before.Remaining -= issue.Quantity
+
+	err = app.recordItemChange(tx, r, "returned", &before, item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordEvent(tx, "issue.returned", issue.ItemID, envelope{"issue": issue})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"issue": issue}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Always sorted by issued_at
+func (app *application) listIssues(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromParams(r)
+	if err != nil || id < 1 {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	app.listLedger(w, r, id, app.issueList("-issued_at"))
+}
+
+// Lists issues across all items, each with the name of its item
+func (app *application) listAllIssues(w http.ResponseWriter, r *http.Request) {
+	app.listLedger(w, r, 0, app.issueList("-issued_at"))
+}
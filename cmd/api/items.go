@@ -1,7 +1,9 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -55,9 +57,10 @@ func (app *application) addItem(w http.ResponseWriter, r *http.Request) {
 	}
 
 	addition := &data.Addition{
-		ItemID:   item.ID,
-		Quantity: item.Quantity,
-		Remarks:  input.Remarks,
+		ItemID:    item.ID,
+		Quantity:  item.Quantity,
+		Remarks:   input.Remarks,
+		CreatedBy: app.contextGetUserID(r),
 	}
 
 	err = app.additions.InsertAddition(tx, addition)
@@ -66,6 +69,24 @@ func (app *application) addItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = app.recordItemChange(tx, r, "created", nil, item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordEvent(tx, "item.created", item.ID, envelope{"item": item})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -97,6 +118,35 @@ func (app *application) getItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	qs := r.URL.Query()
+
+	asOf := app.readAsOf(qs, v)
+	withForecast := app.readBool(qs, "forecast", true, v)
+	window := app.readInt(qs, "window", app.config.forecast.window, v)
+	if data.ValidateForecastWindow(v, window); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !asOf.IsZero() {
+		item, err := app.items.GetItemAsOf(id, asOf)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrNoRecord):
+				app.notFoundErrorResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"item": item, "as_of": asOf}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	item, err := app.items.GetItem(id)
 	if err != nil {
 		switch {
@@ -108,7 +158,42 @@ func (app *application) getItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"item": item}, nil)
+	// The forecast changes with time as well as with the item, so a response
+	// with it has an ETag of its own
+	var forecast *data.Forecast
+	etag := itemETag(item)
+
+	if withForecast {
+		forecast, err = app.forecasts.GetForItem(item.ID, window)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrNoRecord):
+				app.notFoundErrorResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		etag, err = forecastETag(item, forecast)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	env := envelope{"item": item}
+	if withForecast {
+		env["forecast"] = forecast
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, http.Header{"ETag": []string{etag}})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -116,9 +201,8 @@ func (app *application) getItem(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) getItems(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name    string
-		Remarks string
-		TagID   int
+		data.ItemFilter
+		TagMode string
 		data.Filters
 	}
 
@@ -128,40 +212,60 @@ func (app *application) getItems(w http.ResponseWriter, r *http.Request) {
 
 	input.Name = app.readString(qs, "name", "")
 	input.Remarks = app.readString(qs, "remarks", "")
-	input.TagID = app.readInt(qs, "tag_id", 0, v)
+	input.Search = app.readString(qs, "q", "")
+	input.TagMode = app.readString(qs, "tag_mode", "any")
+	input.Tags = data.ParseTagFilter(qs["tag"], qs["tag_id"], input.TagMode)
+	input.AsOf = app.readAsOf(qs, v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "remarks", "created_at", "-id", "-name", "-remarks", "-created_at"}
+	input.Filters.SortSafelist = []string{"id", "name", "remarks", "created_at", "relevance", "-id", "-name", "-remarks", "-created_at"}
+	input.Filters.NoCursorSort = []string{"remarks", "relevance"}
+	input.Filters.Expression = app.readExpression(qs, data.ItemFilterFields, v)
+	input.Filters.CursorFields = data.ItemFilterFields
+	input.Filters.After = app.readString(qs, "after", "")
+	input.Filters.IncludeTotal = app.readBool(qs, "include_total", false, v)
+
+	// Search results are ranked by relevance unless asked otherwise
+	if input.Search != "" && qs.Get("sort") == "" {
+		input.Filters.Sort = "relevance"
+	}
+
+	v.Check(input.Search != "" || input.Filters.Sort != "relevance", "sort", "relevance requires a search query q")
+	data.ValidateTagFilter(v, input.Tags, input.TagMode)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	items, metadata, err := app.items.GetAllItems(input.Name, input.Remarks, input.TagID, input.Filters)
+	items, metadata, err := app.items.GetAllItems(input.ItemFilter, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"items": items, "metadata": metadata}, nil)
+	env := envelope{"items": items, "metadata": metadata}
+	if !input.AsOf.IsZero() {
+		env["as_of"] = input.AsOf
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) updateItem(w http.ResponseWriter, r *http.Request) {
-	params := httprouter.ParamsFromContext(r.Context())
-
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := app.readIdFromParams(r)
 	if err != nil {
 		app.notFoundErrorResponse(w, r)
 		return
 	}
 
 	var input struct {
-		Remaining int32 `json:"remaining"`
+		Remaining int32  `json:"remaining"`
+		Reason    string `json:"reason"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -170,6 +274,14 @@ func (app *application) updateItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	v := validator.New()
+	v.Check(input.Remaining >= 0, "remaining", "must not be negative")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	item, err := app.items.GetItem(id)
 	if err != nil {
 		switch {
@@ -178,23 +290,177 @@ func (app *application) updateItem(w http.ResponseWriter, r *http.Request) {
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	if !app.checkItemPrecondition(w, r, item) {
+		return
 	}
 
+	before := *item
 	item.Remaining = input.Remaining
 
-	err = app.items.UpdateItem(item)
+	app.saveItem(w, r, &before, item, input.Reason)
+}
+
+// Edits any of the item's fields, leaving out the ones absent from the body
+func (app *application) patchItem(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromParams(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Quantity  *int32  `json:"quantity"`
+		Remaining *int32  `json:"remaining"`
+		Remarks   *string `json:"remarks"`
+		Reason    string  `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	item, err := app.items.GetItem(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecord):
 			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkItemPrecondition(w, r, item) {
+		return
+	}
+
+	before := *item
+
+	if input.Name != nil {
+		item.Name = *input.Name
+	}
+	if input.Quantity != nil {
+		item.Quantity = *input.Quantity
+	}
+	if input.Remaining != nil {
+		item.Remaining = *input.Remaining
+	}
+	if input.Remarks != nil {
+		item.Remarks = *input.Remarks
+	}
+
+	v := validator.New()
+	v.Check(item.Name != "", "name", "must be provided")
+	v.Check(len(item.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(item.Quantity > 0, "quantity", "must be greater than 0")
+	v.Check(item.Remaining >= 0, "remaining", "must not be negative")
+
+	// Refills can take an item's remaining stock past its quantity, so edits
+	// that leave both alone are not held up by it
+	if input.Quantity != nil || input.Remaining != nil {
+		v.Check(item.Remaining <= item.Quantity, "remaining", "must not be more than quantity")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.saveItem(w, r, &before, item, input.Reason)
+}
+
+// Checks the If-Match header of a change to an item, if there is one, so that
+// a client cannot overwrite a version it has not seen. Writes 412 when it does
+// not match and returns false then.
+func (app *application) checkItemPrecondition(w http.ResponseWriter, r *http.Request, item *data.Item) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !itemETagMatches(ifMatch, item) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// Saves an edited item at the version it was read, recording the change in
+// its history and any change of its quantity or remaining stock as an
+// adjustment in the same transaction
+func (app *application) saveItem(w http.ResponseWriter, r *http.Request, before *data.Item, item *data.Item, reason string) {
+	tx, err := app.items.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.items.UpdateItem(tx, item)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"item": item}, nil)
+	if item.Remaining != before.Remaining || item.Quantity != before.Quantity {
+		if reason == "" {
+			reason = "item edited"
+		}
+
+		adjustment := &data.Adjustment{
+			ItemID:         item.ID,
+			Quantity:       item.Remaining - before.Remaining,
+			QuantityChange: item.Quantity - before.Quantity,
+			Remarks:        reason,
+		}
+
+		err = app.adjustments.InsertAdjustment(tx, adjustment)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.recordItemChange(tx, r, "updated", before, item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordEvent(tx, "item.updated", item.ID, envelope{"item": item})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordLowStock(tx, before, item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"item": item}, http.Header{"ETag": []string{itemETag(item)}})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -209,7 +475,7 @@ func (app *application) deleteItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.items.DeleteItem(id)
+	item, err := app.items.GetItem(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecord):
@@ -217,9 +483,122 @@ func (app *application) deleteItem(w http.ResponseWriter, r *http.Request) {
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+		return
+	}
+
+	if !app.checkItemPrecondition(w, r, item) {
+		return
+	}
+
+	tx, err := app.items.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Recorded first, while the item still has its tags
+	err = app.recordEvent(tx, "item.deleted", item.ID, envelope{"item": item})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
+
+	err = app.items.DeleteItem(tx, item.ID, item.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			app.notFoundErrorResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.recordItemChange(tx, r, "deleted", item, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, nil, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) listItemHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromParams(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 10, v)
+	filters.Sort = app.readString(qs, "sort", "-changed_at")
+	filters.SortSafelist = []string{"id", "-id", "changed_at", "-changed_at"}
+	filters.After = app.readString(qs, "after", "")
+	filters.IncludeTotal = app.readBool(qs, "include_total", false, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	history, metadata, err := app.history.GetForItem(id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"history": history, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Records the change of an item from before to after in its history, as part
+// of tx. Either is nil when the item is created or deleted.
+func (app *application) recordItemChange(tx *sql.Tx, r *http.Request, action string, before, after *data.Item) error {
+	entry := &data.HistoryEntry{
+		UserID:  app.contextGetUserID(r),
+		Action:  action,
+		Changes: data.DiffItems(before, after),
+	}
+
+	if len(entry.Changes) == 0 {
+		return nil
+	}
+
+	if after != nil {
+		entry.ItemID = after.ID
+	} else {
+		entry.ItemID = before.ID
+	}
+
+	app.auditChange(r, fmt.Sprintf("item:%d", entry.ItemID), entry.Changes)
+
+	return app.history.Insert(tx, entry)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"test.com/internal/data"
+	"test.com/internal/jsonlog"
+)
+
+func TestCheckItemPrecondition(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelOff)}
+	item := &data.Item{ID: 5, Version: 3}
+
+	forecastTag, err := forecastETag(item, &data.Forecast{ItemID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+	}{
+		{"missing", "", 0},
+		{"current", `"5-3"`, 0},
+		{"current with forecast", forecastTag, 0},
+		{"any", "*", 0},
+		{"stale", `"5-2"`, http.StatusPreconditionFailed},
+		{"other version prefix", `"5-30"`, http.StatusPreconditionFailed},
+		{"listed", `"5-2", "5-3"`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/items/5", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+
+			ok := app.checkItemPrecondition(w, r, item)
+
+			switch {
+			case tt.status == 0 && !ok:
+				t.Errorf("got %d, want the request to go ahead", w.Code)
+			case tt.status != 0 && (ok || w.Code != tt.status):
+				t.Errorf("got %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestGetItemsRejectsInvalidFilters(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelOff)}
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"field not on the allow-list", "filter=password_hash%3D'x'"},
+		{"unknown operator", "filter=id%5E1"},
+		{"nested too deeply", "filter=" + strings.Repeat("not+", 21) + "id%3D1"},
+		{"too long", "filter=name%3D" + strings.Repeat("a", 1000)},
+		{"injection", "filter=id%3D1%3B+DROP+TABLE+items"},
+		{"invalid time zone", "filter=created_at%3E2024-01-01&tz=Nowhere%2FCity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/items?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			app.getItems(w, r)
+
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+			}
+		})
+	}
+}
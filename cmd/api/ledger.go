@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"test.com/internal/data"
+	"test.com/internal/validator"
+)
+
+// ledgerList is one of the issue, removal and addition lists, which share
+// their query parameters.
+type ledgerList struct {
+	// key names the rows in the response
+	key         string
+	timeColumn  string
+	defaultSort string
+	fields      data.FilterFields
+	get         func(data.LedgerFilter, data.Filters) (interface{}, data.Metadata, error)
+}
+
+func (app *application) issueList(defaultSort string) ledgerList {
+	return ledgerList{
+		key:         "issues",
+		timeColumn:  "issued_at",
+		defaultSort: defaultSort,
+		fields:      data.IssueFilterFields,
+		get: func(filter data.LedgerFilter, filters data.Filters) (interface{}, data.Metadata, error) {
+			return app.issues.GetIssues(filter, filters)
+		},
+	}
+}
+
+func (app *application) removalList(defaultSort string) ledgerList {
+	return ledgerList{
+		key:         "removals",
+		timeColumn:  "removed_at",
+		defaultSort: defaultSort,
+		fields:      data.RemovalFilterFields,
+		get: func(filter data.LedgerFilter, filters data.Filters) (interface{}, data.Metadata, error) {
+			return app.removals.GetRemovals(filter, filters)
+		},
+	}
+}
+
+func (app *application) additionList(defaultSort string) ledgerList {
+	return ledgerList{
+		key:         "additions",
+		timeColumn:  "added_at",
+		defaultSort: defaultSort,
+		fields:      data.AdditionFilterFields,
+		get: func(filter data.LedgerFilter, filters data.Filters) (interface{}, data.Metadata, error) {
+			return app.additions.GetAdditions(filter, filters)
+		},
+	}
+}
+
+// Lists the rows of the ledger, each with the name of its item. A non-zero
+// itemID restricts the list to that item.
+func (app *application) listLedger(w http.ResponseWriter, r *http.Request, itemID int64, list ledgerList) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filter := app.readLedgerFilter(qs, list, v)
+	if itemID != 0 {
+		filter.ItemID = itemID
+	}
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 10, v)
+	filters.Sort = app.readString(qs, "sort", list.defaultSort)
+	filters.SortSafelist = []string{"id", "-id", list.timeColumn, "-" + list.timeColumn}
+	filters.Expression = app.readExpression(qs, list.fields, v)
+	filters.CursorFields = list.fields
+	filters.After = app.readString(qs, "after", "")
+	filters.IncludeTotal = app.readBool(qs, "include_total", false, v)
+
+	data.ValidateLedgerFilter(v, filter, app.readString(qs, "tag_mode", "any"))
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rows, metadata, err := list.get(filter, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{list.key: rows, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Return the ledger filters from the query, rejecting those that do not apply
+// to the list
+func (app *application) readLedgerFilter(qs url.Values, list ledgerList, v *validator.Validator) data.LedgerFilter {
+	if _, ok := list.fields["issued_to"]; !ok && qs.Has("issued_to") {
+		v.AddError("issued_to", "is only supported on issues")
+	}
+
+	return data.LedgerFilter{
+		ItemID:      int64(app.readInt(qs, "item_id", 0, v)),
+		ItemName:    app.readString(qs, "item_name", ""),
+		From:        app.readTime(qs, "from", false, v),
+		To:          app.readTime(qs, "to", true, v),
+		IssuedTo:    app.readString(qs, "issued_to", ""),
+		MinQuantity: app.readInt(qs, "min_quantity", 0, v),
+		MaxQuantity: app.readInt(qs, "max_quantity", 0, v),
+		Tags:        data.ParseTagFilter(qs["tag"], qs["tag_id"], app.readString(qs, "tag_mode", "any")),
+		CreatedBy:   int64(app.readInt(qs, "created_by", 0, v)),
+	}
+}
@@ -3,13 +3,18 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
 	"test.com/internal/data"
 	"test.com/internal/jsonlog"
+	"test.com/internal/mailer"
 )
 
 type config struct {
@@ -18,20 +23,67 @@ type config struct {
 	db   struct {
 		dsn string
 	}
+	forecast struct {
+		window int
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	password       data.PasswordPolicy
+	commonPassword struct {
+		list string
+	}
+	passwordReset struct {
+		ttl time.Duration
+	}
+	// How long sessions last for each type of client
+	clients  map[string]data.SessionTTL
+	lowStock struct {
+		threshold int
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	jobs struct {
+		tokenCleanup       string
+		idempotencyCleanup string
+		digest             string
+		outboxCleanup      string
+		emailCleanup       string
+	}
 }
 
 type application struct {
-	config      config
-	logger      *jsonlog.Logger
-	items       *data.ItemModel
-	issues      *data.IssueModel
-	removals    *data.RemovalModel
-	additions   *data.AdditionModel
-	users       *data.UserModel
-	tags        *data.TagModel
-	tokens      *data.TokenModel
-	permissions *data.PermissionModel
-	org         *data.OrganizationsModel
+	config        config
+	logger        *jsonlog.Logger
+	items         *data.ItemModel
+	issues        *data.IssueModel
+	removals      *data.RemovalModel
+	additions     *data.AdditionModel
+	adjustments   *data.AdjustmentModel
+	users         *data.UserModel
+	tags          *data.TagModel
+	tokens        *data.TokenModel
+	permissions   *data.PermissionModel
+	org           *data.OrganizationsModel
+	forecasts     *data.ForecastModel
+	idempotency   *data.IdempotencyModel
+	history       *data.HistoryModel
+	audits        *data.AuditModel
+	webhooks      *data.WebhookModel
+	subscriptions *data.SubscriptionModel
+	jobRuns       *data.JobRunModel
+	outbox        *data.OutboxModel
+	apiKeys       *data.APIKeyModel
+	sinks         []eventSink
+	emails        *data.EmailModel
+	mailer        mailer.Mailer
+	events        *eventBroker
+	wg            sync.WaitGroup
 }
 
 func main() {
@@ -40,9 +92,56 @@ func main() {
 
 	flag.StringVar(&config.db.dsn, "dsn", os.Getenv("TEST_DB_DSN"), "PostgreSQL DSN")
 	flag.StringVar(&config.env, "env", "development", "Environment (development|staging|production)")
+	flag.DurationVar(&config.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key responses are kept for replay")
+	flag.IntVar(&config.forecast.window, "forecast-window", 30, "Look-back window in days used for stock forecasts")
+	flag.IntVar(&config.password.MinLength, "password-min-length", 8, "Minimum length of a new password in characters")
+	flag.IntVar(&config.password.MaxLength, "password-max-length", data.MaxPasswordBytes, "Maximum length of a new password in characters")
+	flag.BoolVar(&config.password.RequireUpper, "password-require-upper", false, "Require new passwords to contain an upper case letter")
+	flag.BoolVar(&config.password.RequireLower, "password-require-lower", false, "Require new passwords to contain a lower case letter")
+	flag.BoolVar(&config.password.RequireDigit, "password-require-digit", false, "Require new passwords to contain a digit")
+	flag.BoolVar(&config.password.RequireSymbol, "password-require-symbol", false, "Require new passwords to contain a symbol")
+	flag.BoolVar(&config.password.RejectCommon, "password-reject-common", true, "Reject new passwords found in the bundled list of common passwords")
+	flag.StringVar(&config.commonPassword.list, "password-common-list", "", "File of further common passwords to reject, one per line, such as a breached password list")
+	flag.DurationVar(&config.passwordReset.ttl, "password-reset-ttl", 30*time.Minute, "How long a password reset token can be used for")
+	config.clients = map[string]data.SessionTTL{
+		"default": {Access: 15 * time.Minute, Refresh: 7 * 24 * time.Hour},
+		"kiosk":   {Access: time.Hour, Refresh: 90 * 24 * time.Hour},
+		"scanner": {Access: time.Hour, Refresh: 90 * 24 * time.Hour},
+	}
+	flag.Func("client-ttls", `Access and refresh token lifetimes per client type, as "name=access/refresh,..." (default "default=15m/168h,kiosk=1h/2160h,scanner=1h/2160h")`, func(s string) error {
+		clients, err := parseClientTTLs(s)
+		if err != nil {
+			return err
+		}
+		config.clients = clients
+		return nil
+	})
+	flag.IntVar(&config.lowStock.threshold, "low-stock-threshold", 5, "Remaining stock at or below which an item is low on stock")
+	flag.StringVar(&config.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&config.smtp.port, "smtp-port", 1025, "SMTP port")
+	flag.StringVar(&config.smtp.username, "smtp-username", "", "SMTP username, leave empty for no authentication")
+	flag.StringVar(&config.smtp.password, "smtp-password", "", "SMTP password")
+	flag.StringVar(&config.smtp.sender, "smtp-sender", "Sqirrel <no-reply@sqirrel.local>", "SMTP sender")
+	flag.StringVar(&config.jobs.tokenCleanup, "token-cleanup-schedule", "@hourly", "Cron schedule on which expired tokens are deleted, or empty to disable it")
+	flag.StringVar(&config.jobs.idempotencyCleanup, "idempotency-cleanup-schedule", "@hourly", "Cron schedule on which expired idempotency keys are deleted, or empty to disable it")
+	flag.StringVar(&config.jobs.outboxCleanup, "outbox-cleanup-schedule", "@daily", "Cron schedule on which dispatched outbox events are deleted, or empty to disable it")
+	flag.StringVar(&config.jobs.emailCleanup, "email-cleanup-schedule", "@daily", "Cron schedule on which sent and failed emails are deleted, or empty to disable it")
+	flag.StringVar(&config.jobs.digest, "digest-schedule", "0 7 * * *", "Cron schedule on which the daily digest is emailed, or empty to disable it")
 	flag.Parse()
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	err := config.password.Check()
+	if err != nil {
+		logger.PrintFatal(err, map[string]string{})
+	}
+
+	if config.commonPassword.list != "" {
+		err = data.LoadCommonPasswords(config.commonPassword.list)
+		if err != nil {
+			logger.PrintFatal(err, map[string]string{})
+		}
+	}
+
 	db, err := openDB(config.db.dsn)
 	if err != nil {
 		logger.PrintFatal(err, map[string]string{})
@@ -51,17 +150,35 @@ func main() {
 	defer db.Close()
 
 	app := &application{
-		items:       &data.ItemModel{DB: db},
-		issues:      &data.IssueModel{DB: db},
-		removals:    &data.RemovalModel{DB: db},
-		additions:   &data.AdditionModel{DB: db},
-		tags:        &data.TagModel{DB: db},
-		org:         &data.OrganizationsModel{DB: db},
-		users:       &data.UserModel{DB: db},
-		tokens:      &data.TokenModel{DB: db},
-		permissions: &data.PermissionModel{DB: db},
-		logger:      logger,
-		config:      config,
+		items:         &data.ItemModel{DB: db},
+		issues:        &data.IssueModel{DB: db},
+		removals:      &data.RemovalModel{DB: db},
+		additions:     &data.AdditionModel{DB: db},
+		adjustments:   &data.AdjustmentModel{DB: db},
+		tags:          &data.TagModel{DB: db},
+		org:           &data.OrganizationsModel{DB: db},
+		users:         &data.UserModel{DB: db},
+		tokens:        &data.TokenModel{DB: db},
+		permissions:   &data.PermissionModel{DB: db},
+		forecasts:     &data.ForecastModel{DB: db},
+		idempotency:   &data.IdempotencyModel{DB: db},
+		history:       &data.HistoryModel{DB: db},
+		audits:        &data.AuditModel{DB: db},
+		webhooks:      &data.WebhookModel{DB: db},
+		subscriptions: &data.SubscriptionModel{DB: db},
+		jobRuns:       &data.JobRunModel{DB: db},
+		outbox:        &data.OutboxModel{DB: db},
+		apiKeys:       &data.APIKeyModel{DB: db},
+		emails:        &data.EmailModel{DB: db},
+		mailer:        mailer.New(config.smtp.host, config.smtp.port, config.smtp.username, config.smtp.password, config.smtp.sender),
+		events:        newEventBroker(),
+		logger:        logger,
+		config:        config,
+	}
+
+	app.sinks = []eventSink{
+		webhookSink{webhooks: app.webhooks},
+		notificationSink{app: app},
 	}
 
 	err = app.serve()
@@ -70,6 +187,45 @@ func main() {
 	}
 }
 
+// Parses the -client-ttls flag, which must include the default client type
+func parseClientTTLs(s string) (map[string]data.SessionTTL, error) {
+	clients := make(map[string]data.SessionTTL)
+
+	for _, entry := range strings.Split(s, ",") {
+		name, ttls, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid client %q, want name=access/refresh", entry)
+		}
+
+		accessPart, refreshPart, ok := strings.Cut(ttls, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid client %q, want name=access/refresh", entry)
+		}
+
+		access, err := time.ParseDuration(accessPart)
+		if err != nil {
+			return nil, err
+		}
+
+		refresh, err := time.ParseDuration(refreshPart)
+		if err != nil {
+			return nil, err
+		}
+
+		if access <= 0 || refresh < access {
+			return nil, fmt.Errorf("client %q must have a positive access lifetime no longer than its refresh lifetime", name)
+		}
+
+		clients[name] = data.SessionTTL{Access: access, Refresh: refresh}
+	}
+
+	if _, ok := clients["default"]; !ok {
+		return nil, errors.New(`the default client type must be given`)
+	}
+
+	return clients, nil
+}
+
 func openDB(dsn string) (*sql.DB, error) {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"test.com/internal/data"
 	"test.com/internal/validator"
@@ -35,6 +39,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			user, err := app.userForAPIKey(headerParts[1])
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrNoRecord):
+					app.invalidAPIKeyResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -48,7 +69,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.users.GetForToken(token)
+		user, err := app.users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrNoRecord):
@@ -59,11 +80,66 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		err = app.tokens.Touch(token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Returns the user for a request made with an API key, which acts as itself
+// with the key's permissions. The user's ID is that of the admin who created
+// the key, which only scopes the key's idempotency keys.
+func (app *application) userForAPIKey(keyPlaintext string) (*data.User, error) {
+	v := validator.New()
+	if data.ValidateAPIKeyPlaintext(v, keyPlaintext); !v.Valid() {
+		return nil, data.ErrNoRecord
+	}
+
+	key, err := app.apiKeys.GetForKey(keyPlaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.apiKeys.Touch(key.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &data.User{
+		ID:       *key.CreatedBy,
+		UserName: key.Name,
+		APIKeyID: &key.ID,
+	}
+
+	return user, nil
+}
+
+// Looks up the user's current permissions, or those of the API key the user
+// acts through. A key that can no longer be used has none.
+func (app *application) currentPermissions(user *data.User) (data.Permissions, error) {
+	if user.APIKeyID == nil {
+		return app.permissions.GetAllForUser(user.ID)
+	}
+
+	key, err := app.apiKeys.Get(*user.APIKeyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return key.Permissions, nil
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -77,6 +153,23 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
+// Only lets through users signed in with a token, not an API key, for
+// endpoints about the user's own account
+func (app *application) requireUserAccount(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.APIKeyID != nil {
+			app.userAccountRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
@@ -99,7 +192,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			next.ServeHTTP(w, r)
 			return
 		}
-		permissions, err := app.permissions.GetAllForUser(user.ID)
+		permissions, err := app.currentPermissions(user)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -115,3 +208,124 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 
 	return app.requireAuthenticatedUser(fn)
 }
+
+// responseRecorder passes a response through while keeping its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// responseBuffer holds a response back until flush is called, so that a
+// middleware can still replace it after the handler has written it
+type responseBuffer struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (buf *responseBuffer) WriteHeader(status int) {
+	buf.status = status
+}
+
+func (buf *responseBuffer) Write(b []byte) (int, error) {
+	return buf.body.Write(b)
+}
+
+func (buf *responseBuffer) flush() {
+	buf.ResponseWriter.WriteHeader(buf.status)
+	buf.ResponseWriter.Write(buf.body.Bytes())
+}
+
+// How long a request may hold its Idempotency-Key before a retry may claim it
+// again. It is longer than the server's write timeout.
+const idempotencyLease = time.Minute
+
+// Makes a POST safe to retry: a request repeating the Idempotency-Key of an
+// earlier request by the same user gets the original response replayed instead
+// of being processed again
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		user := app.contextGetUser(r)
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		requestHash := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+
+		// Each API key has keys of its own, apart from its user's
+		if user.APIKeyID != nil {
+			key = fmt.Sprintf("api_key:%d:%s", *user.APIKeyID, key)
+		}
+
+		stored, reserved, err := app.idempotency.Reserve(user.ID, key, requestHash[:], app.config.idempotency.ttl, idempotencyLease)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !reserved {
+			switch {
+			case !bytes.Equal(stored.RequestHash, requestHash[:]):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case stored.Status == 0:
+				app.idempotencyKeyInUseResponse(w, r)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Response)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		// Free the key if the request panics, so that it can be retried
+		defer func() {
+			if err := recover(); err != nil {
+				app.idempotency.Release(stored)
+				panic(err)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		// Server errors and edit conflicts may well succeed when retried, so
+		// they are not kept for replay
+		if rec.status >= http.StatusInternalServerError || rec.status == http.StatusConflict {
+			err = app.idempotency.Release(stored)
+		} else {
+			err = app.idempotency.Complete(stored, rec.status, rec.body.Bytes())
+		}
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"test.com/internal/data"
+	"test.com/internal/mailer"
+)
+
+const (
+	emailMaxAttempts  = 8
+	emailBaseDelay    = time.Minute
+	emailMaxDelay     = 6 * time.Hour
+	emailPollInterval = 5 * time.Second
+	emailBatchSize    = 20
+	emailRetention    = 7 * 24 * time.Hour
+)
+
+// How long a worker holds the emails it claims. It is longer than the
+// mailer's timeout.
+const emailLease = time.Minute
+
+// Queues an email to every user subscribed to the item telling them that it is
+// low on stock, as part of tx
+func (app *application) queueLowStockEmails(tx *sql.Tx, item *data.Item) error {
+	recipients, err := app.subscriptions.GetRecipientsForItem(item.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, user := range recipients {
+		data := map[string]interface{}{
+			"UserName":  user.UserName,
+			"Item":      item,
+			"Threshold": app.config.lowStock.threshold,
+		}
+
+		err := app.queueEmail(tx, user.Email, "low_stock.tmpl", data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Renders an email and queues it for the email worker as part of tx
+func (app *application) queueEmail(tx *sql.Tx, recipient, templateFile string, templateData interface{}) error {
+	msg, err := mailer.Render(templateFile, templateData)
+	if err != nil {
+		return err
+	}
+
+	email := &data.Email{
+		Recipient: recipient,
+		Subject:   msg.Subject,
+		PlainBody: msg.PlainBody,
+		HTMLBody:  msg.HTMLBody,
+	}
+
+	return app.emails.Enqueue(tx, email)
+}
+
+// Queues the daily digest for every subscribed user. The digests are queued
+// together, so that a run that fails part way can be retried without sending
+// any of them twice.
+func (app *application) queueDigests() error {
+	digests, err := app.subscriptions.GetDigests(app.config.lowStock.threshold)
+	if err != nil {
+		return err
+	}
+
+	tx, err := app.emails.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, digest := range digests {
+		err := app.queueEmail(tx, digest.Email, "digest.tmpl", digest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Sends an email, retrying a couple of times as SMTP servers fail transiently
+func (app *application) sendEmail(recipient, templateFile string, data interface{}) error {
+	var err error
+
+	for i := 1; i <= 3; i++ {
+		err = app.mailer.Send(recipient, templateFile, data)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(time.Duration(i) * time.Second)
+	}
+
+	app.logger.PrintError(err, map[string]string{"template": templateFile})
+
+	return err
+}
+
+// Sends due emails from the queue until ctx is cancelled
+func (app *application) runEmailWorker(ctx context.Context) {
+	ticker := time.NewTicker(emailPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		emails, err := app.emails.ClaimDue(emailBatchSize, emailLease)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, email := range emails {
+			wg.Add(1)
+			go func(email *data.Email) {
+				defer wg.Done()
+				app.sendQueuedEmail(email)
+			}(email)
+		}
+		wg.Wait()
+	}
+}
+
+// Makes one attempt at sending a queued email and records the outcome,
+// scheduling a retry with exponential backoff if it failed
+func (app *application) sendQueuedEmail(email *data.Email) {
+	now := time.Now()
+
+	msg := &mailer.Message{Subject: email.Subject, PlainBody: email.PlainBody, HTMLBody: email.HTMLBody}
+
+	err := app.mailer.SendMessage(email.Recipient, msg)
+
+	switch {
+	case err == nil:
+		email.Status = data.EmailSent
+		email.SentAt = &now
+		email.LastError = ""
+	case email.Attempts >= emailMaxAttempts:
+		email.Status = data.EmailFailed
+		email.LastError = err.Error()
+	default:
+		delay := emailBaseDelay << (email.Attempts - 1)
+		if delay > emailMaxDelay || delay <= 0 {
+			delay = emailMaxDelay
+		}
+		email.NextAttemptAt = now.Add(delay)
+		email.LastError = err.Error()
+	}
+
+	err = app.emails.RecordAttempt(email)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"email_id": strconv.FormatInt(email.ID, 10)})
+	}
+}
+
+func (app *application) deleteFinishedEmails() error {
+	deleted, err := app.emails.DeleteFinished(emailRetention)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("Deleted finished emails", map[string]string{"deleted": strconv.FormatInt(deleted, 10)})
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"test.com/internal/data"
+)
+
+const (
+	outboxBatchSize    = 50
+	outboxPollInterval = time.Second
+	outboxMaxAttempts  = 10
+	outboxMaxDelay     = time.Hour
+	outboxRetention    = 7 * 24 * time.Hour
+)
+
+// eventSink is given each event dispatched from the outbox, in the transaction
+// that dispatches it. What a sink writes in that transaction commits together
+// with the record that it took the event, so a sink is given each event once
+// even when the event is retried for another sink.
+type eventSink interface {
+	// Name identifies the sink in the record of the events it has taken
+	Name() string
+	Deliver(tx *sql.Tx, event *data.OutboxEvent) error
+}
+
+// webhookSink queues the event for the webhooks subscribed to it.
+type webhookSink struct {
+	webhooks *data.WebhookModel
+}
+
+func (s webhookSink) Name() string {
+	return "webhooks"
+}
+
+func (s webhookSink) Deliver(tx *sql.Tx, event *data.OutboxEvent) error {
+	body, err := event.Message()
+	if err != nil {
+		return err
+	}
+
+	return s.webhooks.Enqueue(tx, event.Event, body)
+}
+
+// notificationSink queues emails to the subscribers of an item that is low on
+// stock. The emails are sent by the email worker, which retries them on its
+// own.
+type notificationSink struct {
+	app *application
+}
+
+func (s notificationSink) Name() string {
+	return "notifications"
+}
+
+func (s notificationSink) Deliver(tx *sql.Tx, event *data.OutboxEvent) error {
+	if event.Event != "item.low_stock" {
+		return nil
+	}
+
+	var payload struct {
+		Item data.Item `json:"item"`
+	}
+
+	err := json.Unmarshal(event.Payload, &payload)
+	if err != nil {
+		return err
+	}
+
+	return s.app.queueLowStockEmails(tx, &payload.Item)
+}
+
+// memorySink keeps the events it is given, for tests.
+type memorySink struct {
+	mu     sync.Mutex
+	events []*data.OutboxEvent
+}
+
+func (s *memorySink) Name() string {
+	return "memory"
+}
+
+func (s *memorySink) Deliver(tx *sql.Tx, event *data.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+
+	return nil
+}
+
+func (s *memorySink) Events() []*data.OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*data.OutboxEvent(nil), s.events...)
+}
+
+// Saves the event to the outbox in the transaction that made the change
+func (app *application) recordEvent(tx *sql.Tx, event string, itemID int64, payload interface{}) error {
+	return app.outbox.Insert(tx, event, itemID, payload)
+}
+
+// Records item.low_stock when a change takes the item's remaining stock from
+// above the low stock threshold to at or below it
+func (app *application) recordLowStock(tx *sql.Tx, before, after *data.Item) error {
+	threshold := int32(app.config.lowStock.threshold)
+
+	if before.Remaining > threshold && after.Remaining <= threshold {
+		return app.recordEvent(tx, "item.low_stock", after.ID, envelope{"item": after, "threshold": threshold})
+	}
+
+	return nil
+}
+
+// Dispatches the events in the outbox to the sinks until ctx is cancelled
+func (app *application) runOutboxDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			dispatched, err := app.dispatchOutbox()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+			if err != nil || dispatched < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// Gives a batch of pending events to every sink that has not taken them yet.
+// An event that a sink fails to take is retried with exponential backoff, for
+// that sink only.
+func (app *application) dispatchOutbox() (int, error) {
+	tx, err := app.outbox.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	events, err := app.outbox.GetPending(tx, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range events {
+		delivered, err := app.outbox.GetDeliveredSinks(tx, event.ID)
+		if err != nil {
+			return 0, err
+		}
+
+		var sinkErrs []error
+		for _, sink := range app.sinks {
+			if delivered[sink.Name()] {
+				continue
+			}
+
+			err := app.deliverToSink(tx, sink, event)
+			if err != nil {
+				sinkErrs = append(sinkErrs, fmt.Errorf("%s: %w", sink.Name(), err))
+			}
+		}
+
+		if len(sinkErrs) == 0 {
+			err = app.outbox.MarkDispatched(tx, event.ID)
+		} else {
+			err = app.recordDispatchFailure(tx, event, errors.Join(sinkErrs...))
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(events), tx.Commit()
+}
+
+// Gives the event to the sink inside a savepoint, recording the delivery if it
+// succeeds and undoing whatever the sink wrote if it fails, so that the rest of
+// the batch is unaffected
+func (app *application) deliverToSink(tx *sql.Tx, sink eventSink, event *data.OutboxEvent) error {
+	_, err := tx.Exec("SAVEPOINT outbox_sink")
+	if err != nil {
+		return err
+	}
+
+	err = sink.Deliver(tx, event)
+	if err == nil {
+		err = app.outbox.RecordDelivery(tx, event.ID, sink.Name())
+	}
+	if err != nil {
+		if _, rollbackErr := tx.Exec("ROLLBACK TO SAVEPOINT outbox_sink"); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+
+	_, err = tx.Exec("RELEASE SAVEPOINT outbox_sink")
+	return err
+}
+
+func (app *application) recordDispatchFailure(tx *sql.Tx, event *data.OutboxEvent, dispatchErr error) error {
+	app.logger.PrintError(dispatchErr, map[string]string{"outbox_id": strconv.FormatInt(event.ID, 10)})
+
+	attempts := event.Attempts + 1
+
+	status := data.OutboxPending
+	if attempts >= outboxMaxAttempts {
+		status = data.OutboxFailed
+	}
+
+	delay := time.Second << attempts
+	if delay > outboxMaxDelay {
+		delay = outboxMaxDelay
+	}
+
+	return app.outbox.RecordFailure(tx, event.ID, status, time.Now().Add(delay), dispatchErr)
+}
+
+func (app *application) deleteDispatchedEvents() error {
+	deleted, err := app.outbox.DeleteDispatched(outboxRetention)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("Deleted dispatched outbox events", map[string]string{"deleted": strconv.FormatInt(deleted, 10)})
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"test.com/internal/data"
+	"test.com/internal/jsonlog"
+)
+
+// newTestApplication connects to the migrated database in TEST_DB_DSN, skipping
+// the test when it is not set, and returns an application dispatching to sinks.
+func newTestApplication(t *testing.T, sinks ...eventSink) *application {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		outbox: &data.OutboxModel{DB: db},
+		sinks:  sinks,
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+	}
+}
+
+// recordTestEvent commits an event of its own type to the outbox.
+func recordTestEvent(t *testing.T, app *application) string {
+	t.Helper()
+
+	event := "test." + t.Name()
+
+	tx, err := app.outbox.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err := app.recordEvent(tx, event, 0, envelope{"test": true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { app.outbox.DB.Exec("DELETE FROM outbox WHERE event = $1", event) })
+
+	return event
+}
+
+// dispatchAll dispatches until no more events are due.
+func dispatchAll(t *testing.T, app *application) {
+	t.Helper()
+
+	for {
+		dispatched, err := app.dispatchOutbox()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dispatched < outboxBatchSize {
+			return
+		}
+	}
+}
+
+func countDelivered(sink *memorySink, event string) int {
+	n := 0
+	for _, e := range sink.Events() {
+		if e.Event == event {
+			n++
+		}
+	}
+	return n
+}
+
+// failingSink fails to take every event, counting the attempts.
+type failingSink struct {
+	mu       sync.Mutex
+	attempts int
+}
+
+func (s *failingSink) Name() string {
+	return "failing"
+}
+
+func (s *failingSink) Deliver(tx *sql.Tx, event *data.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts++
+
+	return errors.New("sink unavailable")
+}
+
+func (s *failingSink) Attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempts
+}
+
+func TestDispatchOutboxDeliversOnce(t *testing.T) {
+	sink := &memorySink{}
+	app := newTestApplication(t, sink)
+
+	event := recordTestEvent(t, app)
+
+	dispatchAll(t, app)
+	dispatchAll(t, app)
+
+	if n := countDelivered(sink, event); n != 1 {
+		t.Errorf("delivered %d times; want 1", n)
+	}
+}
+
+func TestDispatchOutboxRetriesFailures(t *testing.T) {
+	sink := &memorySink{}
+	failing := &failingSink{}
+	app := newTestApplication(t, sink, failing)
+
+	event := recordTestEvent(t, app)
+
+	dispatchAll(t, app)
+
+	if n := countDelivered(sink, event); n != 1 {
+		t.Errorf("delivered %d times; want 1", n)
+	}
+
+	var status string
+	var attempts int
+	var nextAttemptAt time.Time
+
+	err := app.outbox.DB.QueryRow("SELECT status, attempts, next_attempt_at FROM outbox WHERE event = $1", event).Scan(&status, &attempts, &nextAttemptAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status != data.OutboxPending {
+		t.Errorf("status = %q; want %q", status, data.OutboxPending)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d; want 1", attempts)
+	}
+	if !nextAttemptAt.After(time.Now()) {
+		t.Errorf("next attempt at %v; want a retry in the future", nextAttemptAt)
+	}
+
+	// Once due again, the event is retried
+	_, err = app.outbox.DB.Exec("UPDATE outbox SET next_attempt_at = NOW() WHERE event = $1", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dispatchAll(t, app)
+
+	// Only the sink that failed is given the event again
+	if n := countDelivered(sink, event); n != 1 {
+		t.Errorf("delivered %d times; want 1 after the retry", n)
+	}
+	if n := failing.Attempts(); n != 2 {
+		t.Errorf("failing sink given the event %d times; want 2", n)
+	}
+}
+
+// sqlErrorSink fails with an error from the database, which aborts the
+// transaction it runs in unless it is rolled back to a savepoint.
+type sqlErrorSink struct{}
+
+func (sqlErrorSink) Name() string {
+	return "sql_error"
+}
+
+func (sqlErrorSink) Deliver(tx *sql.Tx, event *data.OutboxEvent) error {
+	_, err := tx.Exec("SELECT 1 / 0")
+	return err
+}
+
+func TestDispatchOutboxIsolatesSinkErrors(t *testing.T) {
+	sink := &memorySink{}
+	app := newTestApplication(t, sqlErrorSink{}, sink)
+
+	event := recordTestEvent(t, app)
+
+	dispatchAll(t, app)
+
+	if n := countDelivered(sink, event); n != 1 {
+		t.Errorf("delivered %d times; want 1", n)
+	}
+
+	var delivered bool
+	err := app.outbox.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM outbox_deliveries
+			INNER JOIN outbox ON outbox.id = outbox_deliveries.event_id
+			WHERE outbox.event = $1 AND outbox_deliveries.sink = $2)`, event, sink.Name()).Scan(&delivered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !delivered {
+		t.Error("the delivery to the sink that took the event was not recorded")
+	}
+}
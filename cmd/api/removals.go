@@ -33,41 +33,60 @@ func (app *application) addRemoval(w http.ResponseWriter, r *http.Request) {
 	}
 
 	removal := &data.Removal{
-		ItemID:   input.ItemID,
-		Quantity: input.Quantity,
-		Remarks:  input.Remarks,
+		ItemID:    input.ItemID,
+		Quantity:  input.Quantity,
+		Remarks:   input.Remarks,
+		CreatedBy: app.contextGetUserID(r),
 	}
 
-	item, err := app.items.GetItem(removal.ItemID)
+	tx, err := app.removals.DB.Begin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	item, err := app.items.UpdateRemaining(tx, removal.ItemID, removal.Quantity)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecord):
 			app.notFoundErrorResponse(w, r)
+		case errors.Is(err, data.ErrInsufficientStock):
+			app.insufficientStockResponse(w, r, item)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	if item.Remaining < removal.Quantity {
-		app.failedValidationResponse(w, r, map[string]string{"item": "item is not available in the required quantity"})
+	err = app.removals.InsertRemoval(tx, removal)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	before := *item
+	before.Remaining += removal.Quantity
+
+	err = app.recordItemChange(tx, r, "removed", &before, item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	tx, err := app.removals.DB.Begin()
+	err = app.recordEvent(tx, "removal.created", removal.ItemID, envelope{"removal": removal})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	defer tx.Rollback()
 
-	err = app.removals.InsertRemoval(tx, removal)
+	err = app.recordLowStock(tx, &before, item)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.items.UpdateRemaining(tx, removal.ItemID, removal.Quantity, item.Version)
+	err = app.recordAudit(tx, r)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -84,46 +103,15 @@ func (app *application) addRemoval(w http.ResponseWriter, r *http.Request) {
 
 func (app *application) listRemovals(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIdFromParams(r)
-	if err != nil {
+	if err != nil || id < 1 {
 		app.notFoundErrorResponse(w, r)
 		return
 	}
 
-	v := validator.New()
-
-	qs := r.URL.Query()
-	var input struct {
-		ItemID int64
-		data.Filters
-	}
-	input.ItemID = id
-	input.Filters.Page = app.readInt(qs, "page", 1, v)
-	input.Filters.PageSize = app.readInt(qs, "page_size", 10, v)
-	input.Filters.Sort = app.readString(qs, "sort", "removed_at")
-	input.Filters.SortSafelist = []string{"id", "-id", "removed_at", "-removed_at"}
-
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	v.Check(input.ItemID != 0, "item_id", "Field cannot be blank")
-	v.Check(input.ItemID > 0, "item_id", "Field cannot be negative")
-
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	removals, metadata, err := app.removals.GetRemovals(input.ItemID, input.Filters)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	err = app.writeJSON(w, http.StatusOK, envelope{"removals": removals, "metadata": metadata}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-	}
+	app.listLedger(w, r, id, app.removalList("removed_at"))
+}
 
+// Lists removals across all items, each with the name of its item
+func (app *application) listAllRemovals(w http.ResponseWriter, r *http.Request) {
+	app.listLedger(w, r, 0, app.removalList("-removed_at"))
 }
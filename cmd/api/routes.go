@@ -24,27 +24,59 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/healthcheck", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodGet, "/items", app.requirePermission("read", app.getItems))
 	router.HandlerFunc(http.MethodGet, "/items/:id", app.requirePermission("read", app.getItem))
-	router.HandlerFunc(http.MethodPost, "/items", app.requirePermission("write", app.addItem))
-	router.HandlerFunc(http.MethodPut, "/items/:id", app.requirePermission("write", app.updateItem))
-	router.HandlerFunc(http.MethodDelete, "/items/:id", app.requirePermission("write", app.deleteItem))
+	router.HandlerFunc(http.MethodGet, "/items/:id/history", app.requirePermission("read", app.listItemHistory))
+	router.HandlerFunc(http.MethodPost, "/items", app.requirePermission("write", app.audit("item.create", app.addItem)))
+	router.HandlerFunc(http.MethodPut, "/items/:id", app.requirePermission("write", app.audit("item.update", app.updateItem)))
+	router.HandlerFunc(http.MethodPatch, "/items/:id", app.requirePermission("write", app.audit("item.update", app.patchItem)))
+	router.HandlerFunc(http.MethodDelete, "/items/:id", app.requirePermission("write", app.audit("item.delete", app.deleteItem)))
+	router.HandlerFunc(http.MethodGet, "/events", app.requirePermission("read", app.streamEvents))
+	router.HandlerFunc(http.MethodGet, "/forecasts/running-out", app.requirePermission("read", app.listRunningOut))
+	router.HandlerFunc(http.MethodGet, "/issues", app.requirePermission("read", app.listAllIssues))
 	router.HandlerFunc(http.MethodGet, "/issues/:id", app.requirePermission("read", app.listIssues))
-	router.HandlerFunc(http.MethodPost, "/issues", app.requirePermission("issue", app.addIssue))
-	router.HandlerFunc(http.MethodPost, "/removals", app.requirePermission("write", app.addRemoval))
+	router.HandlerFunc(http.MethodPost, "/issues", app.requirePermission("issue", app.idempotent(app.audit("issue.create", app.addIssue))))
+	router.HandlerFunc(http.MethodPost, "/returns", app.requirePermission("issue", app.idempotent(app.audit("issue.return", app.returnIssue))))
+	router.HandlerFunc(http.MethodPost, "/checkouts", app.requirePermission("issue", app.idempotent(app.audit("checkout.create", app.addCheckout))))
+	router.HandlerFunc(http.MethodPost, "/removals", app.requirePermission("write", app.idempotent(app.audit("removal.create", app.addRemoval))))
+	router.HandlerFunc(http.MethodGet, "/removals", app.requirePermission("read", app.listAllRemovals))
 	router.HandlerFunc(http.MethodGet, "/removals/:id", app.requirePermission("read", app.listRemovals))
-	router.HandlerFunc(http.MethodPost, "/additions", app.requirePermission("write", app.refillItem))
+	router.HandlerFunc(http.MethodPost, "/additions", app.requirePermission("write", app.idempotent(app.audit("addition.create", app.refillItem))))
+	router.HandlerFunc(http.MethodGet, "/additions", app.requirePermission("read", app.listAllRefills))
 	router.HandlerFunc(http.MethodGet, "/additions/:id", app.requirePermission("read", app.listRefills))
-	router.HandlerFunc(http.MethodPost, "/tags", app.requireAdmin(app.insertTag))
+	router.HandlerFunc(http.MethodPost, "/tags", app.requireAdmin(app.audit("tag.create", app.insertTag)))
 	router.HandlerFunc(http.MethodGet, "/tags", app.requirePermission("read", app.getAllTags))
-	router.HandlerFunc(http.MethodDelete, "/tags", app.requireAdmin(app.removeTag))
-	router.HandlerFunc(http.MethodPost, "/tags/item", app.requirePermission("write", app.addItemTag))
-	router.HandlerFunc(http.MethodDelete, "/tags/item", app.requirePermission("write", app.removeItemTag))
+	router.HandlerFunc(http.MethodDelete, "/tags", app.requireAdmin(app.audit("tag.delete", app.removeTag)))
+	router.HandlerFunc(http.MethodPost, "/tags/item", app.requirePermission("write", app.audit("item_tag.create", app.addItemTag)))
+	router.HandlerFunc(http.MethodDelete, "/tags/item", app.requirePermission("write", app.audit("item_tag.delete", app.removeItemTag)))
 	router.HandlerFunc(http.MethodGet, "/tags/item/:id", app.requirePermission("read", app.listItemTags))
-	router.HandlerFunc(http.MethodPost, "/users", app.registerUser)
+	router.HandlerFunc(http.MethodPost, "/users", app.audit("user.register", app.registerUser))
 	router.HandlerFunc(http.MethodGet, "/users", app.requireAdmin(app.getAllUsers))
-	router.HandlerFunc(http.MethodPost, "/tokens/authentication", app.createAuthenticationToken)
+	router.HandlerFunc(http.MethodPost, "/tokens/authentication", app.audit("token.create", app.createAuthenticationToken))
+	router.HandlerFunc(http.MethodPost, "/tokens/refresh", app.audit("token.refresh", app.refreshAuthenticationToken))
+	router.HandlerFunc(http.MethodDelete, "/tokens/authentication", app.requireUserAccount(app.audit("token.delete", app.deleteAuthenticationToken)))
 	router.HandlerFunc(http.MethodPost, "/tokens/validate", app.validateToken)
-	router.HandlerFunc(http.MethodPost, "/users/permissions", app.requireAdmin(app.updatePermission))
+	router.HandlerFunc(http.MethodPost, "/tokens/password-reset", app.audit("token.password_reset", app.createPasswordResetToken))
+	router.HandlerFunc(http.MethodPut, "/users/password", app.audit("user.password_reset", app.updateUserPassword))
+	router.HandlerFunc(http.MethodPost, "/users/password-reset-tokens", app.requireAdmin(app.audit("token.password_reset", app.issuePasswordResetToken)))
+	router.HandlerFunc(http.MethodPost, "/users/permissions", app.requireAdmin(app.audit("permission.update", app.updatePermission)))
 	router.HandlerFunc(http.MethodGet, "/users/permissions/:id", app.requireAdmin(app.getUserPermissionById))
+	router.HandlerFunc(http.MethodPatch, "/users/me", app.requireUserAccount(app.audit("user.update", app.updateCurrentUser)))
+	router.HandlerFunc(http.MethodPut, "/users/me/password", app.requireUserAccount(app.audit("user.password_update", app.updateCurrentUserPassword)))
+	router.HandlerFunc(http.MethodGet, "/users/me/sessions", app.requireUserAccount(app.listSessions))
+	router.HandlerFunc(http.MethodDelete, "/users/me/sessions/:id", app.requireUserAccount(app.audit("session.delete", app.deleteSession)))
+	router.HandlerFunc(http.MethodDelete, "/users/sessions/:id", app.requireAdmin(app.audit("user.sessions_delete", app.deleteUserSessions)))
+	router.HandlerFunc(http.MethodGet, "/users/me/subscriptions", app.requirePermission("read", app.requireUserAccount(app.listSubscriptions)))
+	router.HandlerFunc(http.MethodPost, "/users/me/subscriptions", app.requirePermission("read", app.requireUserAccount(app.audit("subscription.create", app.createSubscription))))
+	router.HandlerFunc(http.MethodDelete, "/users/me/subscriptions/:id", app.requirePermission("read", app.requireUserAccount(app.audit("subscription.delete", app.deleteSubscription))))
+	router.HandlerFunc(http.MethodGet, "/audit", app.requireAdmin(app.listAudit))
+	router.HandlerFunc(http.MethodPost, "/webhooks", app.requireAdmin(app.audit("webhook.create", app.createWebhook)))
+	router.HandlerFunc(http.MethodGet, "/webhooks", app.requireAdmin(app.listWebhooks))
+	router.HandlerFunc(http.MethodDelete, "/webhooks/:id", app.requireAdmin(app.audit("webhook.delete", app.deleteWebhook)))
+	router.HandlerFunc(http.MethodGet, "/webhooks/:id/deliveries", app.requireAdmin(app.listWebhookDeliveries))
+	router.HandlerFunc(http.MethodPost, "/webhook-deliveries/:id/replay", app.requireAdmin(app.audit("webhook_delivery.replay", app.replayWebhookDelivery)))
+	router.HandlerFunc(http.MethodPost, "/api-keys", app.requireAdmin(app.audit("api_key.create", app.createAPIKey)))
+	router.HandlerFunc(http.MethodGet, "/api-keys", app.requireAdmin(app.listAPIKeys))
+	router.HandlerFunc(http.MethodDelete, "/api-keys/:id", app.requireAdmin(app.audit("api_key.delete", app.deleteAPIKey)))
+	router.HandlerFunc(http.MethodGet, "/job-runs", app.requireAdmin(app.listJobRuns))
 
-	return app.recoverPanic(app.authenticate(router))
+	return app.recoverPanic(app.requestID(app.authenticate(router)))
 }
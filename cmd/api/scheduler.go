@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"test.com/internal/cron"
+	"test.com/internal/data"
+	"test.com/internal/validator"
+)
+
+// job is periodic work run by the scheduler.
+type job struct {
+	name     string
+	schedule *cron.Schedule
+	run      func() error
+}
+
+// Builds the jobs to schedule from the config. A job with an empty schedule is
+// disabled.
+func (app *application) jobs() ([]job, error) {
+	specs := []struct {
+		name string
+		spec string
+		run  func() error
+	}{
+		{"token_cleanup", app.config.jobs.tokenCleanup, app.deleteExpiredTokens},
+		{"idempotency_cleanup", app.config.jobs.idempotencyCleanup, app.deleteExpiredIdempotencyKeys},
+		{"outbox_cleanup", app.config.jobs.outboxCleanup, app.deleteDispatchedEvents},
+		{"email_cleanup", app.config.jobs.emailCleanup, app.deleteFinishedEmails},
+		{"digest", app.config.jobs.digest, app.queueDigests},
+	}
+
+	jobs := []job{}
+
+	for _, s := range specs {
+		if s.spec == "" {
+			continue
+		}
+
+		schedule, err := cron.Parse(s.spec)
+		if err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, job{name: s.name, schedule: schedule, run: s.run})
+	}
+
+	return jobs, nil
+}
+
+// Runs each job on its schedule until ctx is cancelled. A run in progress is
+// allowed to finish.
+func (app *application) runScheduler(ctx context.Context, jobs []job) {
+	for _, j := range jobs {
+		j := j
+		app.background(func() {
+			for {
+				next := j.schedule.Next(time.Now())
+				if next.IsZero() {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(next)):
+				}
+
+				app.runJob(j, next)
+			}
+		})
+	}
+}
+
+// Runs the job for the time it was scheduled for and records the run, unless
+// another replica is running the job or has already run it for that time
+func (app *application) runJob(j job, scheduledFor time.Time) {
+	properties := map[string]string{"job": j.name, "scheduled_for": scheduledFor.Format(time.RFC3339)}
+
+	unlock, locked, err := app.jobRuns.TryLock(j.name)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		return
+	}
+	if !locked {
+		return
+	}
+	defer unlock()
+
+	run, started, err := app.jobRuns.Start(j.name, scheduledFor)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+		return
+	}
+	if !started {
+		return
+	}
+
+	runErr := j.run()
+	if runErr != nil {
+		app.logger.PrintError(runErr, properties)
+	}
+
+	err = app.jobRuns.Finish(run, runErr)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+	}
+}
+
+func (app *application) deleteExpiredTokens() error {
+	deleted, err := app.tokens.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("Deleted expired tokens", map[string]string{"deleted": strconv.FormatInt(deleted, 10)})
+
+	return nil
+}
+
+func (app *application) deleteExpiredIdempotencyKeys() error {
+	deleted, err := app.idempotency.DeleteExpired(app.config.idempotency.ttl)
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("Deleted expired idempotency keys", map[string]string{"deleted": strconv.FormatInt(deleted, 10)})
+
+	return nil
+}
+
+func (app *application) listJobRuns(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	job := app.readString(qs, "job", "")
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-id")
+	filters.SortSafelist = []string{"id", "-id"}
+	filters.After = app.readString(qs, "after", "")
+	filters.IncludeTotal = app.readBool(qs, "include_total", false, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	runs, metadata, err := app.jobRuns.GetAll(job, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"job_runs": runs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -12,6 +12,11 @@ import (
 )
 
 func (app *application) serve() error {
+	jobs, err := app.jobs()
+	if err != nil {
+		return err
+	}
+
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
@@ -20,8 +25,30 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	srv.RegisterOnShutdown(app.events.closeAll)
+
 	shutdownErr := make(chan error)
 
+	workers, stopWorkers := context.WithCancel(context.Background())
+
+	app.background(func() {
+		app.runWebhookWorker(workers)
+	})
+
+	app.background(func() {
+		app.runOutboxDispatcher(workers)
+	})
+
+	app.background(func() {
+		app.runEmailWorker(workers)
+	})
+
+	app.background(func() {
+		app.runEventListener(workers)
+	})
+
+	app.runScheduler(workers, jobs)
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -35,7 +62,19 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
-		shutdownErr <- srv.Shutdown(ctx)
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownErr <- err
+			return
+		}
+
+		app.logger.PrintInfo("Completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		stopWorkers()
+		app.wg.Wait()
+		shutdownErr <- nil
 	}()
 
 	app.logger.PrintInfo("Starting server", map[string]string{
@@ -43,7 +82,7 @@ func (app *application) serve() error {
 		"env":  app.config.env,
 	})
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
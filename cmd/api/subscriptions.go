@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"test.com/internal/data"
+	"test.com/internal/validator"
+)
+
+func (app *application) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	subscriptions, err := app.subscriptions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"subscriptions": subscriptions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Subscribes the authenticated user to an item, or to every item with a tag
+func (app *application) createSubscription(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		ItemID *int64 `json:"item_id"`
+		TagID  *int64 `json:"tag_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check((input.ItemID == nil) != (input.TagID == nil), "item_id", "exactly one of item_id and tag_id must be provided")
+	v.Check(input.ItemID == nil || *input.ItemID > 0, "item_id", "must be greater than 0")
+	v.Check(input.TagID == nil || *input.TagID > 0, "tag_id", "must be greater than 0")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	subscription := &data.Subscription{
+		UserID: user.ID,
+		ItemID: input.ItemID,
+		TagID:  input.TagID,
+	}
+
+	tx, err := app.subscriptions.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.subscriptions.Insert(tx, subscription)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrItemIdDoesNotExists):
+			v.AddError("item_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrTagIdDoesNotExists):
+			v.AddError("tag_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateSubscription):
+			v.AddError("subscription", "already subscribed")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("subscription:%d", subscription.ID), input)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"subscription": subscription}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIdFromParams(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	tx, err := app.subscriptions.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.subscriptions.Delete(tx, id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("subscription:%d", id), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "subscription deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
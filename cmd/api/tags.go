@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
 	"test.com/internal/data"
@@ -30,7 +31,14 @@ func (app *application) insertTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.tags.InsertTag(&tag)
+	tx, err := app.tags.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.tags.InsertTag(tx, &tag)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateName):
@@ -41,6 +49,19 @@ func (app *application) insertTag(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusCreated, envelope{"tag": tag}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -67,7 +88,14 @@ func (app *application) removeTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.tags.DeleteTag(input.ID)
+	tx, err := app.tags.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	tag, err := app.tags.DeleteTag(tx, input.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecord):
@@ -78,6 +106,20 @@ func (app *application) removeTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.auditChange(r, fmt.Sprintf("tag:%d", tag.ID), envelope{"deleted": tag})
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, nil, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -118,7 +160,14 @@ func (app *application) removeItemTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.tags.RemoveItemTag(input.ItemID, input.TagID)
+	tx, err := app.tags.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.tags.RemoveItemTag(tx, input.ItemID, input.TagID)
 
 	if err != nil {
 		switch {
@@ -130,6 +179,20 @@ func (app *application) removeItemTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.auditChange(r, fmt.Sprintf("item:%d", input.ItemID), input)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, nil, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -161,7 +224,14 @@ func (app *application) addItemTag(w http.ResponseWriter, r *http.Request) {
 	itemTag.ItemID = input.ItemID
 	itemTag.TagID = input.TagID
 
-	err = app.tags.InsertItemTag(&itemTag)
+	tx, err := app.tags.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.tags.InsertItemTag(tx, &itemTag)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateItemTag):
@@ -178,6 +248,21 @@ func (app *application) addItemTag(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+
+	app.auditChange(r, fmt.Sprintf("item:%d", itemTag.ItemID), itemTag)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusCreated, envelope{"item_tag": itemTag}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,6 +16,7 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 	var input struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Client   string `json:"client"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -26,7 +29,13 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 	v.Check(input.Username != "", "username", "must be provided")
 	v.Check(len(input.Username) >= 3, "username", "must be at least 3 bytes long")
 	v.Check(input.Password != "", "password", "must be provided")
-	v.Check(len(input.Password) == 4, "password", "must be 4 bytes long")
+	v.Check(len(input.Password) <= data.MaxPasswordBytes, "password", fmt.Sprintf("must not be more than %d bytes long", data.MaxPasswordBytes))
+
+	if input.Client == "" {
+		input.Client = "default"
+	}
+	ttl, ok := app.config.clients[input.Client]
+	v.Check(ok, "client", "unknown client type")
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -55,13 +64,114 @@ func (app *application) createAuthenticationToken(w http.ResponseWriter, r *http
 		return
 	}
 
-	token, err := app.tokens.New(user.ID, 24*time.Hour)
+	tx, err := app.tokens.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	access, refresh, err := app.tokens.NewSession(tx, user.ID, 0, input.Client, ttl, clientIP(r), r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("user:%d", user.ID), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh, "user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Exchanges a refresh token for new access and refresh tokens. Each refresh
+// token can be used once; using one again revokes the session.
+func (app *application) refreshAuthenticationToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.invalidRefreshTokenResponse(w, r)
+		return
+	}
+
+	tx, err := app.tokens.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	old, err := app.tokens.UseRefreshToken(tx, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			err = tx.Commit()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			app.logger.PrintInfo("Refresh token reused, session revoked", map[string]string{
+				"user_id": strconv.FormatInt(old.UserID, 10),
+				"session": strconv.FormatInt(old.Family, 10),
+			})
+			app.invalidRefreshTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ttl, ok := app.config.clients[old.Client]
+	if !ok {
+		ttl = app.config.clients["default"]
+	}
+
+	access, refresh, err := app.tokens.NewSession(tx, old.UserID, old.Family, old.Client, ttl, clientIP(r), r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("user:%d", old.UserID), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "user": user}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": access, "refresh_token": refresh}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -89,7 +199,7 @@ func (app *application) validateToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := app.users.GetForToken(token)
+	user, err := app.users.GetForToken(data.ScopeAuthentication, token)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrNoRecord):
@@ -105,3 +215,294 @@ func (app *application) validateToken(w http.ResponseWriter, r *http.Request) {
 
 	}
 }
+
+// Emails a password reset token to the user with the email address. The
+// response is the same whether or not there is such a user, so that it cannot
+// be used to find out who has an account.
+func (app *application) createPasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Email != "", "email", "must be provided")
+	v.Check(validator.Matches(input.Email, validator.EmailRX), "email", "must be a valid email address")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		tx, err := app.tokens.DB.BeginTx(r.Context(), nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer tx.Rollback()
+
+		token, err := app.tokens.New(tx, user.ID, app.config.passwordReset.ttl, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.auditChange(r, fmt.Sprintf("user:%d", user.ID), nil)
+
+		err = app.recordAudit(tx, r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"UserName": user.UserName,
+				"Token":    token.Plaintext,
+				"Expiry":   token.Expiry.Format(time.RFC1123),
+			}
+
+			app.sendEmail(user.Email, "password_reset.tmpl", data)
+		})
+	case !errors.Is(err, data.ErrNoRecord):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "if a user has that email address, a password reset token has been sent to it"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Issues a password reset token for a user, for an admin to pass on to them
+// such as when they have no email address
+func (app *application) issuePasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.users.Get(input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			v.AddError("user_id", "does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tx, err := app.tokens.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	token, err := app.tokens.New(tx, user.ID, app.config.passwordReset.ttl, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("user:%d", user.ID), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"password_reset_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Signs out by revoking the session of the authentication token the request
+// was made with
+func (app *application) deleteAuthenticationToken(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	tx, err := app.tokens.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.tokens.DeleteFamilyOf(tx, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("user:%d", user.ID), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "signed out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSessions(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.tokens.GetSessionsForUser(user.ID, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSession(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIdFromParams(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	tx, err := app.tokens.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.tokens.DeleteSession(tx, id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("session:%d", id), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Revokes every session of a user, signing them out everywhere
+func (app *application) deleteUserSessions(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromParams(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tx, err := app.tokens.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.tokens.DeleteSessionsForUser(tx, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("user:%d", user.ID), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "sessions revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
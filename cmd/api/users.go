@@ -1,7 +1,9 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -12,6 +14,7 @@ import (
 func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		UserName string `json:"username"`
+		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 
@@ -26,8 +29,8 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 	v := validator.New()
 	v.Check(input.UserName != "", "username", "must be provided")
 	v.Check(len(input.UserName) >= 3, "username", "must be at least 3 bytes long")
-	v.Check(input.Password != "", "password", "must be provided")
-	v.Check(len(input.Password) == 4, "password", "must be 4 bytes long")
+	data.ValidatePassword(v, "password", input.Password, app.config.password)
+	v.Check(input.Email == "" || validator.Matches(input.Email, validator.EmailRX), "email", "must be a valid email address")
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -36,6 +39,7 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 
 	user := &data.User{
 		UserName: input.UserName,
+		Email:    input.Email,
 		Password: input.Password,
 		IsAdmin:  false}
 
@@ -46,18 +50,44 @@ func (app *application) registerUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user.Hash = hash
-	err = app.users.Insert(user)
+
+	tx, err := app.users.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.users.Insert(tx, user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateName):
 			v.AddError("username", "must be unique")
 			app.failedValidationResponse(w, r, v.Errors)
 			return
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
 		}
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.auditChange(r, fmt.Sprintf("user:%d", user.ID), envelope{"username": user.UserName})
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -119,10 +149,17 @@ func (app *application) updatePermission(w http.ResponseWriter, r *http.Request)
 	v.Check(input.UserID > 0, "user_id", "must be greater than zero")
 	v.Check(input.PermissionID > 0, "permission_id", "must be greater than zero")
 
+	tx, err := app.permissions.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
 	if input.Grant {
-		err = app.permissions.AddForUser(input.UserID, input.PermissionID)
+		err = app.permissions.AddForUser(tx, input.UserID, input.PermissionID)
 	} else {
-		err = app.permissions.RemoveForUser(input.UserID, input.PermissionID)
+		err = app.permissions.RemoveForUser(tx, input.UserID, input.PermissionID)
 	}
 	if err != nil {
 		switch {
@@ -145,8 +182,260 @@ func (app *application) updatePermission(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	app.auditChange(r, fmt.Sprintf("user:%d", input.UserID), input)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusCreated, envelope{"message": "permission added"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// Updates the authenticated user's own details. An empty email removes it.
+func (app *application) updateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Email *string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Email != nil {
+		user.Email = strings.TrimSpace(*input.Email)
+	}
+
+	v := validator.New()
+	v.Check(user.Email == "" || validator.Matches(user.Email, validator.EmailRX), "email", "must be a valid email address")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tx, err := app.users.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.users.Update(tx, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("user:%d", user.ID), input)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Changes the password of the current user, who must give their current one
+func (app *application) updateCurrentUserPassword(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePassword(v, "new_password", input.NewPassword, app.config.password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	matches, err := data.CheckPasswordOnHash(input.CurrentPassword, user.Hash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !matches {
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hash, err := data.PasswordToHash(input.NewPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The password and the removal of the reset tokens are committed together,
+	// so a reset token cannot outlive the password it was issued for
+	tx, err := app.users.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.setPassword(tx, user, hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("user:%d", user.ID), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was updated"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Sets a new password using a password reset token, which is used up, and
+// signs the user out everywhere
+func (app *application) updateUserPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Token    string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePassword(v, "password", input.Password, app.config.password)
+	data.ValidateTokenPlaintext(v, input.Token)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hash, err := data.PasswordToHash(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The token is used up in the same transaction that sets the password, so
+	// it cannot be used twice
+	tx, err := app.tokens.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	userID, err := app.tokens.UsePasswordResetToken(tx, input.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.SetHash(tx, userID, hash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("user:%d", userID), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password was reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Saves the user's new password hash and removes any outstanding password
+// reset tokens as part of tx, so that none can be used afterwards
+func (app *application) setPassword(tx *sql.Tx, user *data.User, hash string) error {
+	user.Hash = hash
+
+	err := app.users.Update(tx, user)
+	if err != nil {
+		return err
+	}
+
+	return app.tokens.DeleteScopeForUser(tx, data.ScopePasswordReset, user.ID)
+}
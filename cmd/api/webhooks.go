@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"test.com/internal/data"
+	"test.com/internal/validator"
+)
+
+const (
+	webhookMaxAttempts  = 10
+	webhookBaseDelay    = 30 * time.Second
+	webhookMaxDelay     = 6 * time.Hour
+	webhookPollInterval = 5 * time.Second
+	webhookTimeout      = 10 * time.Second
+	webhookBatchSize    = 20
+	// webhookLease is how long a claimed delivery is kept from other workers.
+	// The batch is sent concurrently, so it outlasts every request's timeout.
+	webhookLease = 2 * webhookTimeout
+)
+
+func (app *application) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+	}
+
+	v := validator.New()
+	if data.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tx, err := app.webhooks.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.webhooks.Insert(tx, webhook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("webhook:%d", webhook.ID), input)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.webhooks.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromParams(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	tx, err := app.webhooks.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	err = app.webhooks.Delete(tx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("webhook:%d", id), nil)
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromParams(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	var filters data.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-id")
+	filters.SortSafelist = []string{"id", "-id"}
+	filters.After = app.readString(qs, "after", "")
+	filters.IncludeTotal = app.readBool(qs, "include_total", false, v)
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.webhooks.GetDeliveries(id, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Sends the payload of an earlier delivery again, as a new delivery
+func (app *application) replayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdFromParams(r)
+	if err != nil {
+		app.notFoundErrorResponse(w, r)
+		return
+	}
+
+	tx, err := app.webhooks.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	delivery, err := app.webhooks.Replay(tx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNoRecord):
+			app.notFoundErrorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.auditChange(r, fmt.Sprintf("webhook_delivery:%d", id), envelope{"replayed_as": delivery.ID})
+
+	err = app.recordAudit(tx, r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"delivery": delivery}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Sends due webhook deliveries until ctx is cancelled
+func (app *application) runWebhookWorker(ctx context.Context) {
+	client := &http.Client{Timeout: webhookTimeout}
+
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deliveries, err := app.webhooks.ClaimDue(webhookBatchSize, webhookLease)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery *data.WebhookDelivery) {
+				defer wg.Done()
+				app.sendWebhook(client, delivery)
+			}(delivery)
+		}
+		wg.Wait()
+	}
+}
+
+// Makes one attempt at a delivery and records the outcome, scheduling a retry
+// with exponential backoff if it failed
+func (app *application) sendWebhook(client *http.Client, delivery *data.WebhookDelivery) {
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(delivery.Secret))
+	mac.Write([]byte(timestamp + "." + string(delivery.Payload)))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	delivery.LastStatusCode = nil
+	delivery.LastError = ""
+
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-Event", delivery.Event)
+		req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+		req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+signature)
+
+		var res *http.Response
+		res, err = client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+			res.Body.Close()
+
+			delivery.LastStatusCode = &res.StatusCode
+			if res.StatusCode < 200 || res.StatusCode > 299 {
+				err = fmt.Errorf("endpoint responded with %s", res.Status)
+			}
+		}
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = data.DeliverySucceeded
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= webhookMaxAttempts:
+		delivery.Status = data.DeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		delay := webhookBaseDelay << (delivery.Attempts - 1)
+		if delay > webhookMaxDelay || delay <= 0 {
+			delay = webhookMaxDelay
+		}
+		delivery.NextAttemptAt = now.Add(delay)
+		delivery.LastError = err.Error()
+	}
+
+	err = app.webhooks.RecordAttempt(delivery)
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"delivery_id": strconv.FormatInt(delivery.ID, 10)})
+	}
+}
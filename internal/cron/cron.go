@@ -0,0 +1,258 @@
+// Package cron parses cron schedules and works out when they next fire.
+//
+// A schedule has five space separated fields: minute, hour, day of month,
+// month and day of week (0 or 7 is Sunday). Each field is *, a value, a range
+// such as 1-5, a step such as */15 or 0-30/10, or a comma separated list of
+// these. The shorthands @hourly, @daily (or @midnight), @weekly, @monthly and
+// @yearly (or @annually) are also accepted.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron schedule.
+type Schedule struct {
+	spec   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// Day of month and day of week match either one when both are restricted
+	domStar bool
+	dowStar bool
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+var fields = []bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// everyHour is the hour set of a schedule that fires in every hour.
+const everyHour = 1<<24 - 1
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron schedule.
+func Parse(spec string) (*Schedule, error) {
+	expanded := strings.TrimSpace(spec)
+	if s, ok := shorthands[expanded]; ok {
+		expanded = s
+	}
+
+	parts := strings.Fields(expanded)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron: %q must have %d fields", spec, len(fields))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron: %q: %w", spec, err)
+		}
+		sets[i] = set
+	}
+
+	// Sunday may be written as 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &Schedule{
+		spec:    spec,
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}, nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+
+	for _, term := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(term, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepPart, b.name)
+			}
+			step = n
+		}
+
+		var lo, hi int
+		switch {
+		case rangePart == "*":
+			lo, hi = b.min, b.max
+		case strings.Contains(rangePart, "-"):
+			loPart, hiPart, _ := strings.Cut(rangePart, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(loPart)
+			hi, err2 = strconv.Atoi(hiPart)
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q in %s", rangePart, b.name)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q in %s", rangePart, b.name)
+			}
+			lo, hi = n, n
+			if hasStep {
+				hi = b.max
+			}
+		}
+
+		if lo < b.min || hi > b.max || lo > hi {
+			return 0, fmt.Errorf("%s must be between %d and %d", b.name, b.min, b.max)
+		}
+
+		for i := lo; i <= hi; i += step {
+			set |= 1 << i
+		}
+	}
+
+	return set, nil
+}
+
+// Next returns the first time after t at which the schedule fires, in t's
+// location. It returns the zero time if the schedule never fires, such as on
+// 30 February.
+//
+// Like cron, a schedule with fixed hours fires once across daylight saving
+// changes: times skipped when the clocks go forward fire as they do, and times
+// repeated when the clocks go back only fire the first time.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Every schedule that can fire does so within a few years
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = startOfHour(t.Year(), t.Month()+1, 1, 0, t.Location())
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = startOfHour(t.Year(), t.Month(), t.Day()+1, 0, t.Location())
+			continue
+		}
+
+		if s.skippedBefore(t) {
+			return t
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = startOfHour(t.Year(), t.Month(), t.Day(), t.Hour()+1, t.Location())
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 || s.repeated(t) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// startOfHour returns the start of the wall clock hour in loc, or the end of
+// the gap when the clocks go forward over it. time.Date returns a time before
+// the gap then, which Next would come back to forever.
+func startOfHour(year int, month time.Month, day, hour int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, 0, 0, 0, loc)
+
+	want := time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	for time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Before(want) {
+		t = t.Add(time.Minute)
+	}
+
+	return t
+}
+
+// skippedBefore reports whether the clocks went forward just before t, over a
+// time the schedule fires at.
+func (s *Schedule) skippedBefore(t time.Time) bool {
+	if s.hour == everyHour {
+		return false
+	}
+
+	// The wall clock times between the minute before t and t
+	prev := t.Add(-time.Minute)
+	from := time.Date(prev.Year(), prev.Month(), prev.Day(), prev.Hour(), prev.Minute()+1, 0, 0, time.UTC)
+	to := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+
+	for w := from; w.Before(to); w = w.Add(time.Minute) {
+		if s.hour&(1<<uint(w.Hour())) != 0 && s.minute&(1<<uint(w.Minute())) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// repeated reports whether the wall clock already showed t's time earlier, as
+// the clocks went back since, for a schedule with fixed hours.
+func (s *Schedule) repeated(t time.Time) bool {
+	if s.hour == everyHour {
+		return false
+	}
+
+	_, offset := t.Zone()
+	_, before := t.Add(-24 * time.Hour).Zone()
+	if before <= offset {
+		return false
+	}
+
+	earlier := t.Add(-time.Duration(before-offset) * time.Second)
+	return earlier.Hour() == t.Hour() && earlier.Minute() == t.Minute()
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dowMatch
+	case s.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
@@ -0,0 +1,167 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		err  string
+	}{
+		{"", "must have 5 fields"},
+		{"* * * *", "must have 5 fields"},
+		{"* * * * * *", "must have 5 fields"},
+		{"@every 5m", "must have 5 fields"},
+		{"60 * * * *", "minute must be between 0 and 59"},
+		{"* 24 * * *", "hour must be between 0 and 23"},
+		{"* * 0 * *", "day of month must be between 1 and 31"},
+		{"* * 32 * *", "day of month must be between 1 and 31"},
+		{"* * * 0 *", "month must be between 1 and 12"},
+		{"* * * 13 *", "month must be between 1 and 12"},
+		{"* * * * 8", "day of week must be between 0 and 7"},
+		{"5-1 * * * *", "minute must be between 0 and 59"},
+		{"0-60 * * * *", "minute must be between 0 and 59"},
+		{"-1 * * * *", "invalid range"},
+		{"1-x * * * *", "invalid range"},
+		{"x * * * *", "invalid value"},
+		{"1,,2 * * * *", "invalid value"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"*/-5 * * * *", "invalid step"},
+		{"MON * * * *", "invalid value"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.spec)
+
+		switch {
+		case err == nil:
+			t.Errorf("%q: expected an error containing %q", tt.spec, tt.err)
+		case !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%q: got error %q, want it to contain %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	date := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", date(2024, 9, 2, 10, 7), date(2024, 9, 2, 10, 8)},
+		{"seconds are dropped", "* * * * *", date(2024, 9, 2, 10, 7).Add(30 * time.Second), date(2024, 9, 2, 10, 8)},
+		{"step", "*/15 * * * *", date(2024, 9, 2, 10, 7), date(2024, 9, 2, 10, 15)},
+		{"step is after from", "*/15 * * * *", date(2024, 9, 2, 10, 15), date(2024, 9, 2, 10, 30)},
+		{"step into the next hour", "*/15 * * * *", date(2024, 9, 2, 10, 45), date(2024, 9, 2, 11, 0)},
+		{"step from a value", "5/10 * * * *", date(2024, 9, 2, 10, 6), date(2024, 9, 2, 10, 15)},
+		{"step from a value wraps", "5/10 * * * *", date(2024, 9, 2, 10, 55), date(2024, 9, 2, 11, 5)},
+		{"step in a range", "0-30/10 * * * *", date(2024, 9, 2, 10, 31), date(2024, 9, 2, 11, 0)},
+		{"list", "0 8,17 * * *", date(2024, 9, 2, 9, 0), date(2024, 9, 2, 17, 0)},
+
+		{"day of month only", "0 0 13 * *", date(2024, 9, 1, 0, 0), date(2024, 9, 13, 0, 0)},
+		{"day of week only", "0 0 * * 5", date(2024, 9, 1, 0, 0), date(2024, 9, 6, 0, 0)},
+		{"day of month or week, week first", "0 0 13 * 5", date(2024, 9, 1, 0, 0), date(2024, 9, 6, 0, 0)},
+		{"day of month or week, month first", "0 0 13 * 5", date(2024, 9, 10, 0, 0), date(2024, 9, 13, 0, 0)},
+		{"day of month or week, both", "0 0 13 * 5", date(2024, 9, 13, 0, 0), date(2024, 9, 20, 0, 0)},
+		{"weekday range", "0 9 * * 1-5", date(2024, 9, 6, 9, 0), date(2024, 9, 9, 9, 0)},
+		{"0 is Sunday", "0 0 * * 0", date(2024, 9, 2, 0, 0), date(2024, 9, 8, 0, 0)},
+		{"7 is Sunday", "0 0 * * 7", date(2024, 9, 2, 0, 0), date(2024, 9, 8, 0, 0)},
+		{"range to 7", "0 0 * * 5-7", date(2024, 9, 7, 0, 0), date(2024, 9, 8, 0, 0)},
+
+		{"@hourly", "@hourly", date(2024, 9, 2, 10, 7), date(2024, 9, 2, 11, 0)},
+		{"@daily", "@daily", date(2024, 9, 2, 10, 7), date(2024, 9, 3, 0, 0)},
+		{"@midnight", "@midnight", date(2024, 9, 2, 10, 7), date(2024, 9, 3, 0, 0)},
+		{"@weekly", "@weekly", date(2024, 9, 2, 10, 7), date(2024, 9, 8, 0, 0)},
+		{"@monthly", "@monthly", date(2024, 9, 2, 10, 7), date(2024, 10, 1, 0, 0)},
+		{"@yearly", "@yearly", date(2024, 9, 2, 10, 7), date(2025, 1, 1, 0, 0)},
+		{"@annually", "@annually", date(2024, 9, 2, 10, 7), date(2025, 1, 1, 0, 0)},
+
+		{"into the next month", "0 0 1 * *", date(2024, 1, 31, 10, 0), date(2024, 2, 1, 0, 0)},
+		{"into the next year", "59 23 31 12 *", date(2024, 12, 31, 23, 59), date(2025, 12, 31, 23, 59)},
+		{"months without the day", "0 0 31 * *", date(2024, 4, 15, 0, 0), date(2024, 5, 31, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(2023, 3, 1, 0, 0), date(2024, 2, 29, 0, 0)},
+		{"after a leap day", "0 0 29 2 *", date(2024, 2, 29, 0, 0), date(2028, 2, 29, 0, 0)},
+		{"never", "0 0 30 2 *", date(2024, 1, 1, 0, 0), time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("%q: unexpected error %v", tt.spec, err)
+			}
+
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("%q from %s: got %s, want %s", tt.spec, tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAcrossDaylightSaving(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+
+	// The clocks go forward from 02:00 to 03:00 on 10 March 2024, and back from
+	// 02:00 to 01:00 on 3 November 2024
+	est := time.FixedZone("EST", -5*60*60)
+	edt := time.FixedZone("EDT", -4*60*60)
+
+	tests := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"skipped time fires as the clocks go forward", "30 2 * * *",
+			time.Date(2024, 3, 10, 1, 0, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"skipped time fires once", "30 2 * * *",
+			time.Date(2024, 3, 10, 3, 0, 0, 0, edt), time.Date(2024, 3, 11, 2, 30, 0, 0, edt)},
+		{"time after the gap", "30 3 * * *",
+			time.Date(2024, 3, 10, 1, 0, 0, 0, est), time.Date(2024, 3, 10, 3, 30, 0, 0, edt)},
+		{"later hour across the gap", "0 7 * * *",
+			time.Date(2024, 3, 10, 1, 30, 0, 0, est), time.Date(2024, 3, 10, 7, 0, 0, 0, edt)},
+		{"hourly skips the missing hour", "0 * * * *",
+			time.Date(2024, 3, 10, 1, 30, 0, 0, est), time.Date(2024, 3, 10, 3, 0, 0, 0, edt)},
+		{"daily is 23 hours apart", "0 12 * * *",
+			time.Date(2024, 3, 9, 12, 0, 0, 0, est), time.Date(2024, 3, 10, 12, 0, 0, 0, edt)},
+
+		{"repeated time fires the first time", "30 1 * * *",
+			time.Date(2024, 11, 3, 0, 0, 0, 0, edt), time.Date(2024, 11, 3, 1, 30, 0, 0, edt)},
+		{"repeated time fires once", "30 1 * * *",
+			time.Date(2024, 11, 3, 1, 30, 0, 0, edt), time.Date(2024, 11, 4, 1, 30, 0, 0, est)},
+		{"hourly runs in the repeated hour", "*/30 * * * *",
+			time.Date(2024, 11, 3, 1, 30, 0, 0, edt), time.Date(2024, 11, 3, 1, 0, 0, 0, est)},
+		{"daily is 25 hours apart", "0 12 * * *",
+			time.Date(2024, 11, 2, 12, 0, 0, 0, edt), time.Date(2024, 11, 3, 12, 0, 0, 0, est)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("%q: unexpected error %v", tt.spec, err)
+			}
+
+			from := tt.from.In(newYork)
+			got := s.Next(from)
+			if !got.Equal(tt.want) {
+				t.Errorf("%q from %s: got %s, want %s", tt.spec, from, got, tt.want.In(newYork))
+			}
+			if got.Location() != newYork {
+				t.Errorf("%q: got location %s, want %s", tt.spec, got.Location(), newYork)
+			}
+		})
+	}
+}
@@ -3,16 +3,19 @@ package data
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"strings"
 	"time"
 )
 
 type Addition struct {
 	ID       int64     `json:"id"`
 	ItemID   int64     `json:"item_id"`
+	ItemName string    `json:"item_name,omitempty"`
 	Quantity int32     `json:"quantity"`
 	Remarks  string    `json:"remarks"`
 	AddedAt  time.Time `json:"added_at"`
+	// CreatedBy is the user who made the addition, nil once they are deleted
+	CreatedBy *int64 `json:"created_by"`
 }
 
 type AdditionModel struct {
@@ -22,25 +25,33 @@ type AdditionModel struct {
 func (m AdditionModel) InsertAddition(tx *sql.Tx, addition *Addition) error {
 	ctx := context.Background()
 	query := `
-		INSERT INTO additions (item_id, quantity, remarks)
-		VALUES ($1, $2, $3)
+		INSERT INTO additions (item_id, quantity, remarks, created_by)
+		VALUES ($1, $2, $3, $4)
 		RETURNING id, added_at
 	`
-	return tx.QueryRowContext(ctx, query, addition.ItemID, addition.Quantity, addition.Remarks).Scan(&addition.ID, &addition.AddedAt)
+	return tx.QueryRowContext(ctx, query, addition.ItemID, addition.Quantity, addition.Remarks, addition.CreatedBy).Scan(&addition.ID, &addition.AddedAt)
 }
 
-func (m AdditionModel) GetAdditions(itemID int64, filters Filters) ([]*Addition, Metadata, error) {
-	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, item_id, quantity, remarks, added_at
+// GetAdditions lists the additions matching filter, each with the name of its item.
+func (m AdditionModel) GetAdditions(filter LedgerFilter, filters Filters) ([]*Addition, Metadata, error) {
+	args := queryArgs{}
+
+	conditions := append(filter.conditions("additions", "added_at", &args), filters.where(&args))
+	where := strings.Join(conditions, " AND ")
+
+	query := `
+		SELECT ` + filters.countColumn("additions INNER JOIN items ON items.id = additions.item_id", where) + `, additions.id, additions.item_id, items.name, additions.quantity, additions.remarks, additions.added_at, additions.created_by
 		FROM additions
-		WHERE item_id = $1
-		ORDER BY %s %s
-		LIMIT %d OFFSET %d`, filters.sortColumn(), filters.sortDirection(), filters.limit(), filters.offset())
+		INNER JOIN items ON items.id = additions.item_id
+		WHERE ` + where + `
+		AND ` + filters.keyset("additions", &args) + `
+		ORDER BY ` + filters.orderBy("additions") + `
+		` + filters.limitOffset(&args)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, itemID)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -55,9 +66,11 @@ func (m AdditionModel) GetAdditions(itemID int64, filters Filters) ([]*Addition,
 			&totalRecords,
 			&addition.ID,
 			&addition.ItemID,
+			&addition.ItemName,
 			&addition.Quantity,
 			&addition.Remarks,
 			&addition.AddedAt,
+			&addition.CreatedBy,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -69,5 +82,15 @@ func (m AdditionModel) GetAdditions(itemID int64, filters Filters) ([]*Addition,
 		return nil, Metadata{}, err
 	}
 
-	return additions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+	additions, metadata := paginate(additions, totalRecords, filters, additionSortValue)
+
+	return additions, metadata, nil
+}
+
+func additionSortValue(addition *Addition, column string) (interface{}, int64) {
+	if column == "added_at" {
+		return addition.AddedAt, addition.ID
+	}
+
+	return addition.ID, addition.ID
 }
@@ -0,0 +1,38 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Adjustment records a correction of an item's stock made by editing the
+// item, as opposed to an issue, removal or addition. Quantity is the change to
+// its remaining stock and QuantityChange the change to its quantity, each
+// negative when it was lowered.
+type Adjustment struct {
+	ID             int64     `json:"id"`
+	ItemID         int64     `json:"item_id"`
+	Quantity       int32     `json:"quantity"`
+	QuantityChange int32     `json:"quantity_change"`
+	Remarks        string    `json:"remarks"`
+	AdjustedAt     time.Time `json:"adjusted_at"`
+}
+
+type AdjustmentModel struct {
+	DB *sql.DB
+}
+
+func (m AdjustmentModel) InsertAdjustment(tx *sql.Tx, adjustment *Adjustment) error {
+	query := `
+		INSERT INTO adjustments (item_id, quantity, quantity_change, remarks)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, adjusted_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{adjustment.ItemID, adjustment.Quantity, adjustment.QuantityChange, adjustment.Remarks}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&adjustment.ID, &adjustment.AdjustedAt)
+}
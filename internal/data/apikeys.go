@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"test.com/internal/validator"
+)
+
+// APIKey lets a machine call the API as itself, limited to the key's
+// permissions. It only works while the admin who created it still is one, and
+// CreatedBy is nil once they are deleted. Key is only shown when the key is
+// created.
+type APIKey struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Key         string     `json:"key,omitempty"`
+	Hash        []byte     `json:"-"`
+	Permissions []string   `json:"permissions"`
+	CreatedBy   *int64     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least one permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		v.Check(validator.In(code, PermissionCodes...), "permissions", "unknown permission "+code)
+	}
+
+	v.Check(key.ExpiresAt == nil || key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "key", "must be provided")
+	v.Check(len(keyPlaintext) == 52, "key", "must be 52 bytes long")
+}
+
+// Insert saves the key with a newly generated secret, of which only the hash
+// is stored.
+func (m APIKeyModel) Insert(tx *sql.Tx, key *APIKey) error {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return err
+	}
+
+	key.Key = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(key.Key))
+	key.Hash = hash[:]
+
+	query := `
+		INSERT INTO api_keys (name, hash, permissions, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{key.Name, key.Hash, pq.Array(key.Permissions), key.CreatedBy, key.ExpiresAt}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+const apiKeyColumns = `api_keys.id, api_keys.name, api_keys.permissions, api_keys.created_by, api_keys.created_at, api_keys.expires_at, api_keys.last_used_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	var key APIKey
+
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		pq.Array(&key.Permissions),
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAll() ([]*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// apiKeyUsable holds for keys that have not expired and whose creator is still
+// an admin.
+const apiKeyUsable = `(api_keys.expires_at IS NULL OR api_keys.expires_at > NOW())
+		AND EXISTS (SELECT 1 FROM users WHERE users.id = api_keys.created_by AND users.is_admin)`
+
+// Get returns the key if it exists and can still be used.
+func (m APIKeyModel) Get(id int64) (*APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE api_keys.id = $1 AND ` + apiKeyUsable
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// GetForKey looks up a key that can still be used by its secret.
+func (m APIKeyModel) GetForKey(keyPlaintext string) (*APIKey, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE api_keys.hash = $1 AND ` + apiKeyUsable
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, keyHash[:]))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// Touch records that the key has been used. It is only written once a minute,
+// so that every request does not update the key.
+func (m APIKeyModel) Touch(id int64) error {
+	query := `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+func (m APIKeyModel) Delete(tx *sql.Tx, id int64) error {
+	if id < 1 {
+		return ErrNoRecord
+	}
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestAPIKeyRequiresAdminCreator(t *testing.T) {
+	db := openTestDB(t)
+	m := APIKeyModel{DB: db}
+
+	admin := insertTestUser(t, db)
+	if _, err := db.Exec("UPDATE users SET is_admin = true WHERE id = $1", admin.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	key := &APIKey{Name: t.Name(), Permissions: []string{"read"}, CreatedBy: &admin.ID}
+	inTestTx(t, db, func(tx *sql.Tx) error { return m.Insert(tx, key) })
+	t.Cleanup(func() { db.Exec("DELETE FROM api_keys WHERE id = $1", key.ID) })
+
+	if _, err := m.GetForKey(key.Key); err != nil {
+		t.Fatalf("GetForKey with an admin creator: %v", err)
+	}
+
+	if _, err := db.Exec("UPDATE users SET is_admin = false WHERE id = $1", admin.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.GetForKey(key.Key); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("GetForKey once the creator is no longer an admin: err = %v; want ErrNoRecord", err)
+	}
+
+	// Deleting the creator keeps the key, which can then only be revoked
+	if _, err := db.Exec("DELETE FROM users WHERE id = $1", admin.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	inTestTx(t, db, func(tx *sql.Tx) error { return m.Delete(tx, key.ID) })
+}
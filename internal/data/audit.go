@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"test.com/internal/validator"
+)
+
+// AuditEntry records a successful write made through the API. ActorID is nil
+// for anonymous requests such as registering or logging in. APIKeyID is set
+// when the actor made the request with one of their API keys. RequestID is
+// generated by the server, while ClientRequestID is whatever X-Request-ID the
+// client sent.
+type AuditEntry struct {
+	ID              int64           `json:"id"`
+	ActorID         *int64          `json:"actor_id"`
+	APIKeyID        *int64          `json:"api_key_id"`
+	Action          string          `json:"action"`
+	Target          string          `json:"target"`
+	RequestID       string          `json:"request_id"`
+	ClientRequestID string          `json:"client_request_id,omitempty"`
+	IP              string          `json:"ip"`
+	Diff            json.RawMessage `json:"diff"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows the audit log. Zero values leave a field unfiltered.
+type AuditFilter struct {
+	ActorID int64
+	Action  string
+	From    time.Time
+	To      time.Time
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+func ValidateAuditFilter(v *validator.Validator, f AuditFilter) {
+	v.Check(f.ActorID >= 0, "actor_id", "must not be negative")
+	v.Check(f.From.IsZero() || f.To.IsZero() || !f.To.Before(f.From), "to", "must not be before from")
+}
+
+// Insert appends the entry to the audit log. Entries are never updated or
+// deleted, which the table enforces with a trigger.
+func (m AuditModel) Insert(entry *AuditEntry) error {
+	return insertAuditEntry(m.DB, entry)
+}
+
+// InsertTx appends the entry to the audit log as part of tx, so that it is
+// committed together with the change it records.
+func (m AuditModel) InsertTx(tx *sql.Tx, entry *AuditEntry) error {
+	return insertAuditEntry(tx, entry)
+}
+
+func insertAuditEntry(db interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, entry *AuditEntry) error {
+	query := `
+		INSERT INTO audit_log (actor_id, action, target, request_id, ip, diff, api_key_id, client_request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var diff interface{}
+	if len(entry.Diff) > 0 {
+		diff = []byte(entry.Diff)
+	}
+
+	args := []interface{}{entry.ActorID, entry.Action, entry.Target, entry.RequestID, entry.IP, diff, entry.APIKeyID, entry.ClientRequestID}
+
+	return db.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+func (m AuditModel) GetAll(filter AuditFilter, filters Filters) ([]*AuditEntry, Metadata, error) {
+	args := queryArgs{}
+	conditions := []string{}
+
+	if filter.ActorID != 0 {
+		conditions = append(conditions, "audit_log.actor_id = "+args.add(filter.ActorID))
+	}
+
+	if filter.Action != "" {
+		conditions = append(conditions, "audit_log.action = "+args.add(filter.Action))
+	}
+
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "audit_log.created_at >= "+args.add(filter.From))
+	}
+
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "audit_log.created_at <= "+args.add(filter.To))
+	}
+
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+
+	query := `
+		SELECT ` + filters.countColumn("audit_log", where) + `, id, actor_id, api_key_id, action, target, request_id, client_request_id, ip, diff, created_at
+		FROM audit_log
+		WHERE ` + where + `
+		AND ` + filters.keyset("audit_log", &args) + `
+		ORDER BY ` + filters.orderBy("audit_log") + `
+		` + filters.limitOffset(&args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var entry AuditEntry
+		var diff []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.ActorID,
+			&entry.APIKeyID,
+			&entry.Action,
+			&entry.Target,
+			&entry.RequestID,
+			&entry.ClientRequestID,
+			&entry.IP,
+			&diff,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if diff != nil {
+			entry.Diff = json.RawMessage(diff)
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	entries, metadata := paginate(entries, totalRecords, filters, auditSortValue)
+
+	return entries, metadata, nil
+}
+
+func auditSortValue(entry *AuditEntry, column string) (interface{}, int64) {
+	if column == "created_at" {
+		return entry.CreatedAt, entry.ID
+	}
+	return entry.ID, entry.ID
+}
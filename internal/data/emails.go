@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// Email is a rendered message queued to be sent, so that sending it is retried
+// apart from whatever queued it.
+type Email struct {
+	ID            int64
+	Recipient     string
+	Subject       string
+	PlainBody     string
+	HTMLBody      string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
+
+type EmailModel struct {
+	DB *sql.DB
+}
+
+// Enqueue queues the email as part of tx, so that it is only sent if tx
+// commits.
+func (m EmailModel) Enqueue(tx *sql.Tx, email *Email) error {
+	query := `
+		INSERT INTO emails (recipient, subject, plain_body, html_body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, status, next_attempt_at, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{email.Recipient, email.Subject, email.PlainBody, email.HTMLBody}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&email.ID, &email.Status, &email.NextAttemptAt, &email.CreatedAt)
+}
+
+// ClaimDue takes up to limit pending emails that are due and counts an attempt
+// for each. They are leased for lease, so that another worker only picks them
+// up again if this one never records the outcome.
+func (m EmailModel) ClaimDue(limit int, lease time.Duration) ([]*Email, error) {
+	query := `
+		UPDATE emails
+		SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM emails
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, subject, plain_body, html_body, status, attempts, next_attempt_at, last_error, created_at, sent_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*Email{}
+
+	for rows.Next() {
+		var email Email
+
+		err := rows.Scan(
+			&email.ID,
+			&email.Recipient,
+			&email.Subject,
+			&email.PlainBody,
+			&email.HTMLBody,
+			&email.Status,
+			&email.Attempts,
+			&email.NextAttemptAt,
+			&email.LastError,
+			&email.CreatedAt,
+			&email.SentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// RecordAttempt saves the outcome of sending an email: its status, when to try
+// again if it is still pending, and the error if it failed.
+func (m EmailModel) RecordAttempt(email *Email) error {
+	query := `
+		UPDATE emails
+		SET status = $1, next_attempt_at = $2, last_error = $3, sent_at = $4
+		WHERE id = $5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{email.Status, email.NextAttemptAt, email.LastError, email.SentAt, email.ID}
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeleteFinished removes emails sent or given up on more than age ago and
+// returns how many were removed.
+func (m EmailModel) DeleteFinished(age time.Duration) (int64, error) {
+	query := `
+		DELETE FROM emails
+		WHERE status <> 'pending' AND created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, time.Now().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
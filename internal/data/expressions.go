@@ -0,0 +1,452 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The filter language combines comparisons with and, or, not and parentheses:
+//
+//	remaining<5 and created_at>2026-01-01 and tag:consumable
+//	(name~cable or name~hdmi) and not tag:archived
+//
+// Comparisons are field, operator and value, where the operator is one of
+// = != < <= > >= ~ (contains) and : (same as =). Values containing spaces or
+// parentheses must be quoted with ' or ". Every field is looked up in the
+// FilterFields of the resource and every value is passed as a query argument.
+
+type FieldType int
+
+const (
+	// FieldInt is an INTEGER column, so its values must fit in 32 bits
+	FieldInt FieldType = iota
+	// FieldBigInt is a BIGINT column
+	FieldBigInt
+	FieldText
+	FieldTime
+	// FieldTag matches the tags, by name, of the item in Column
+	FieldTag
+	// FieldTagID matches the tags, by ID, of the item in Column
+	FieldTagID
+)
+
+// FilterField maps a field of the filter language onto a column.
+type FilterField struct {
+	Column string
+	Type   FieldType
+}
+
+type FilterFields map[string]FilterField
+
+var ItemFilterFields = FilterFields{
+	"id":         {"items.id", FieldInt},
+	"name":       {"items.name", FieldText},
+	"remarks":    {"items.remarks", FieldText},
+	"quantity":   {"items.quantity", FieldInt},
+	"remaining":  {"items.remaining", FieldInt},
+	"created_at": {"items.created_at", FieldTime},
+	"tag":        {"items.id", FieldTag},
+	"tag_id":     {"items.id", FieldTagID},
+}
+
+var IssueFilterFields = FilterFields{
+	"id":         {"issues.id", FieldInt},
+	"item_id":    {"issues.item_id", FieldInt},
+	"quantity":   {"issues.quantity", FieldInt},
+	"issued_to":  {"issues.issued_to", FieldText},
+	"issued_at":  {"issues.issued_at", FieldTime},
+	"due_at":     {"issues.due_at", FieldTime},
+	"tag":        {"issues.item_id", FieldTag},
+	"tag_id":     {"issues.item_id", FieldTagID},
+	"created_by": {"issues.created_by", FieldBigInt},
+}
+
+var RemovalFilterFields = FilterFields{
+	"id":         {"removals.id", FieldInt},
+	"item_id":    {"removals.item_id", FieldInt},
+	"quantity":   {"removals.quantity", FieldInt},
+	"remarks":    {"removals.remarks", FieldText},
+	"removed_at": {"removals.removed_at", FieldTime},
+	"tag":        {"removals.item_id", FieldTag},
+	"tag_id":     {"removals.item_id", FieldTagID},
+	"created_by": {"removals.created_by", FieldBigInt},
+}
+
+var AdditionFilterFields = FilterFields{
+	"id":         {"additions.id", FieldInt},
+	"item_id":    {"additions.item_id", FieldInt},
+	"quantity":   {"additions.quantity", FieldInt},
+	"remarks":    {"additions.remarks", FieldText},
+	"added_at":   {"additions.added_at", FieldTime},
+	"tag":        {"additions.item_id", FieldTag},
+	"tag_id":     {"additions.item_id", FieldTagID},
+	"created_by": {"additions.created_by", FieldBigInt},
+}
+
+const (
+	maxExpressionLength = 1000
+	maxExpressionDepth  = 20
+)
+
+// Expression is a parsed filter, ready to be turned into a SQL condition.
+type Expression struct {
+	root expressionNode
+}
+
+type expressionNode interface {
+	sql(args *queryArgs) string
+}
+
+type logicalNode struct {
+	operator    string
+	left, right expressionNode
+}
+
+func (n logicalNode) sql(args *queryArgs) string {
+	return "(" + n.left.sql(args) + " " + n.operator + " " + n.right.sql(args) + ")"
+}
+
+type notNode struct {
+	operand expressionNode
+}
+
+func (n notNode) sql(args *queryArgs) string {
+	return "NOT (" + n.operand.sql(args) + ")"
+}
+
+type comparisonNode struct {
+	field    FilterField
+	operator string
+	value    interface{}
+}
+
+func (n comparisonNode) sql(args *queryArgs) string {
+	switch {
+	case n.field.Type == FieldTag || n.field.Type == FieldTagID:
+		var tag tagRef
+		if id, ok := n.value.(int64); ok {
+			tag = tagRef{id: id, byID: true}
+		} else {
+			tag = tagRef{name: n.value.(string)}
+		}
+
+		exists := tagExists(n.field.Column, tagCondition(tag, args))
+		if n.operator == "!=" {
+			return "NOT " + exists
+		}
+		return exists
+	case n.operator == "~":
+		return n.field.Column + ` ILIKE '%' || ` + args.add(n.value) + ` || '%'`
+	default:
+		return n.field.Column + " " + n.operator + " " + args.add(n.value)
+	}
+}
+
+// sql returns the SQL condition for the expression, adding its values to args.
+func (e *Expression) sql(args *queryArgs) string {
+	return e.root.sql(args)
+}
+
+// ParseExpression parses a filter against the fields a resource exposes. Dates
+// without a time are read as the start of that day in loc.
+func ParseExpression(input string, fields FilterFields, loc *time.Location) (*Expression, error) {
+	if len(input) > maxExpressionLength {
+		return nil, fmt.Errorf("must not be more than %d bytes long", maxExpressionLength)
+	}
+
+	p := &expressionParser{input: input, fields: fields, loc: loc}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+
+	return &Expression{root: root}, nil
+}
+
+type expressionParser struct {
+	input  string
+	pos    int
+	depth  int
+	fields FilterFields
+	loc    *time.Location
+}
+
+func (p *expressionParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(format+" at position %d", append(args, p.pos+1)...)
+}
+
+func (p *expressionParser) rest() string {
+	rest := p.input[p.pos:]
+	if end := strings.IndexFunc(rest, unicode.IsSpace); end > 0 {
+		return rest[:end]
+	}
+	return rest
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// keyword consumes word if it is next in the input as a whole word.
+func (p *expressionParser) keyword(word string) bool {
+	p.skipSpaces()
+
+	end := p.pos + len(word)
+	if end > len(p.input) || !strings.EqualFold(p.input[p.pos:end], word) {
+		return false
+	}
+	if end < len(p.input) && !unicode.IsSpace(rune(p.input[end])) && p.input[end] != '(' {
+		return false
+	}
+
+	p.pos = end
+	return true
+}
+
+func (p *expressionParser) parseOr() (expressionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{operator: "OR", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (expressionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{operator: "AND", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) parseUnary() (expressionNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > maxExpressionDepth {
+		return nil, p.errorf("expression is nested too deeply")
+	}
+
+	if p.keyword("not") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		p.pos++
+
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+var expressionOperators = []string{"!=", "<=", ">=", "=", "<", ">", "~", ":"}
+
+func (p *expressionParser) parseComparison() (expressionNode, error) {
+	p.skipSpaces()
+
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] == '_' || unicode.IsLetter(rune(p.input[p.pos])) || unicode.IsDigit(rune(p.input[p.pos]))) {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("expected a field name")
+		}
+		return nil, p.errorf("expected a field name, found %q", p.rest())
+	}
+
+	name := strings.ToLower(p.input[start:p.pos])
+	field, ok := p.fields[name]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown field %q", name)
+	}
+
+	p.skipSpaces()
+
+	operator := ""
+	for _, op := range expressionOperators {
+		if strings.HasPrefix(p.input[p.pos:], op) {
+			operator = op
+			break
+		}
+	}
+	if operator == "" {
+		return nil, p.errorf("expected an operator after %q", name)
+	}
+	p.pos += len(operator)
+	if operator == ":" {
+		operator = "="
+	}
+
+	p.skipSpaces()
+	valuePos := p.pos
+
+	raw, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := convertExpressionValue(field, operator, raw, p.loc)
+	if err != nil {
+		p.pos = valuePos
+		return nil, p.errorf("%s: %s", name, err.Error())
+	}
+
+	return comparisonNode{field: field, operator: operator, value: value}, nil
+}
+
+func (p *expressionParser) parseValue() (string, error) {
+	if p.pos >= len(p.input) {
+		return "", p.errorf("expected a value")
+	}
+
+	if quote := p.input[p.pos]; quote == '\'' || quote == '"' {
+		p.pos++
+
+		var b strings.Builder
+		for p.pos < len(p.input) {
+			c := p.input[p.pos]
+			switch {
+			case c == '\\' && p.pos+1 < len(p.input):
+				b.WriteByte(p.input[p.pos+1])
+				p.pos += 2
+			case c == quote:
+				p.pos++
+				return b.String(), nil
+			default:
+				b.WriteByte(c)
+				p.pos++
+			}
+		}
+
+		return "", p.errorf("unterminated string")
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && !unicode.IsSpace(rune(p.input[p.pos])) && p.input[p.pos] != '(' && p.input[p.pos] != ')' {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("expected a value")
+	}
+
+	return p.input[start:p.pos], nil
+}
+
+func convertExpressionValue(field FilterField, operator string, raw string, loc *time.Location) (interface{}, error) {
+	switch field.Type {
+	case FieldInt:
+		if operator == "~" {
+			return nil, fmt.Errorf("operator ~ is only supported on text fields")
+		}
+		return parseExpressionInt(raw, 32)
+
+	case FieldBigInt:
+		if operator == "~" {
+			return nil, fmt.Errorf("operator ~ is only supported on text fields")
+		}
+		return parseExpressionInt(raw, 64)
+
+	case FieldText:
+		if !(operator == "=" || operator == "!=" || operator == "~") {
+			return nil, fmt.Errorf("operator %s is not supported on text fields", operator)
+		}
+		if operator == "~" {
+			return escapeLike(raw), nil
+		}
+		return raw, nil
+
+	case FieldTime:
+		if operator == "~" {
+			return nil, fmt.Errorf("operator ~ is only supported on text fields")
+		}
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		t, err := time.ParseInLocation("2006-01-02", raw, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a date (YYYY-MM-DD) or an RFC 3339 timestamp", raw)
+		}
+		return t, nil
+
+	case FieldTag:
+		if !(operator == "=" || operator == "!=") {
+			return nil, fmt.Errorf("operator %s is not supported on tags", operator)
+		}
+		return raw, nil
+
+	case FieldTagID:
+		if !(operator == "=" || operator == "!=") {
+			return nil, fmt.Errorf("operator %s is not supported on tags", operator)
+		}
+		return parseExpressionInt(raw, 32)
+	}
+
+	return nil, fmt.Errorf("unsupported field")
+}
+
+// parseExpressionInt parses raw as an integer that fits a column of the given
+// bit size, so out of range values are rejected before they reach the database.
+func parseExpressionInt(raw string, bitSize int) (int64, error) {
+	i, err := strconv.ParseInt(raw, 10, bitSize)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("%q is out of range", raw)
+		}
+		return 0, fmt.Errorf("%q is not an integer", raw)
+	}
+	return i, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
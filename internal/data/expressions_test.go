@@ -0,0 +1,168 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExpressionIntRange(t *testing.T) {
+	tests := []struct {
+		input  string
+		fields FilterFields
+		err    string
+	}{
+		{"remaining<2147483647", ItemFilterFields, ""},
+		{"remaining>-2147483648", ItemFilterFields, ""},
+		{"remaining<99999999999", ItemFilterFields, "out of range"},
+		{"id=-2147483649", ItemFilterFields, "out of range"},
+		{"tag_id=99999999999", ItemFilterFields, "out of range"},
+		{"remaining<ten", ItemFilterFields, "not an integer"},
+		{"created_by=99999999999", IssueFilterFields, ""},
+		{"created_by=99999999999999999999", IssueFilterFields, "out of range"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseExpression(tt.input, tt.fields, time.UTC)
+
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.input, err)
+		case tt.err != "" && err == nil:
+			t.Errorf("%s: expected an error containing %q", tt.input, tt.err)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: got error %q, want it to contain %q", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestParseExpressionSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		sql   string
+		args  []interface{}
+	}{
+		{"and binds tighter than or", "id=1 or id=2 and id=3",
+			"(items.id = $1 OR (items.id = $2 AND items.id = $3))", []interface{}{int64(1), int64(2), int64(3)}},
+		{"parentheses", "(id=1 or id=2) and id=3",
+			"((items.id = $1 OR items.id = $2) AND items.id = $3)", []interface{}{int64(1), int64(2), int64(3)}},
+		{"not binds tightest", "not id=1 and id=2",
+			"(NOT (items.id = $1) AND items.id = $2)", []interface{}{int64(1), int64(2)}},
+		{"not of a group", "not (id=1 or id=2)",
+			"NOT ((items.id = $1 OR items.id = $2))", []interface{}{int64(1), int64(2)}},
+		{"keywords ignore case", "id=1 OR NOT id=2",
+			"(items.id = $1 OR NOT (items.id = $2))", []interface{}{int64(1), int64(2)}},
+		{"colon means equals", "remaining:0", "items.remaining = $1", []interface{}{int64(0)}},
+		{"single quotes", "name='a b'", "items.name = $1", []interface{}{"a b"}},
+		{"double quotes", `name="it's"`, "items.name = $1", []interface{}{"it's"}},
+		{"escaped quote", `name='it\'s'`, "items.name = $1", []interface{}{"it's"}},
+		{"keyword in quotes", "name='x or y'", "items.name = $1", []interface{}{"x or y"}},
+		{"contains", "name~bolt", `items.name ILIKE '%' || $1 || '%'`, []interface{}{"bolt"}},
+		{"contains escapes wildcards", `name~'50%_off\\'`, `items.name ILIKE '%' || $1 || '%'`, []interface{}{`50\%\_off\\`}},
+		{"equals keeps wildcards", "name='50%'", "items.name = $1", []interface{}{"50%"}},
+		{"injection is a value", `name="x' OR 1=1; DROP TABLE items; --"`, "items.name = $1", []interface{}{"x' OR 1=1; DROP TABLE items; --"}},
+		{"injection in contains", "remarks~\"%' OR '1'='1\"", `items.remarks ILIKE '%' || $1 || '%'`, []interface{}{`\%' OR '1'='1`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseExpression(tt.input, ItemFilterFields, time.UTC)
+			if err != nil {
+				t.Fatalf("%s: unexpected error %v", tt.input, err)
+			}
+
+			var args queryArgs
+			if got := e.sql(&args); got != tt.sql {
+				t.Errorf("%s: got SQL %q, want %q", tt.input, got, tt.sql)
+			}
+			if !reflect.DeepEqual([]interface{}(args), tt.args) {
+				t.Errorf("%s: got args %#v, want %#v", tt.input, []interface{}(args), tt.args)
+			}
+		})
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"field not on the allow-list", "password_hash='x'", `unknown field "password_hash" at position 1`},
+		{"column of another table", "issues.id=1", `unknown field "issues"`},
+		{"unknown operator", "id==1", `"=1" is not an integer`},
+		{"missing operator", "id 1", `expected an operator after "id"`},
+		{"unsupported operator", "id^1", `expected an operator after "id"`},
+		{"like operator", "name like 'a'", `expected an operator after "name"`},
+		{"contains on a number", "id~1", "operator ~ is only supported on text fields"},
+		{"order on text", "name<'a'", "operator < is not supported on text fields"},
+		{"order on tags", "tag>'a'", "operator > is not supported on tags"},
+		{"unterminated string", "name='abc", "unterminated string"},
+		{"unclosed group", "(id=1", "expected )"},
+		{"trailing input", "id=1 id=2", `unexpected "id=2"`},
+		{"dangling and", "id=1 and", "expected a field name"},
+		{"statement separator", "id=1; DROP TABLE items", `"1;" is not an integer`},
+		{"statement after a string", "name='a'; DROP TABLE items", `unexpected ";"`},
+		{"comment", "id=1 -- x", `unexpected "--"`},
+		{"subquery", "id=(SELECT 1)", "expected a value"},
+		{"depth limit", strings.Repeat("(", maxExpressionDepth) + "id=1" + strings.Repeat(")", maxExpressionDepth), "nested too deeply"},
+		{"not depth limit", strings.Repeat("not ", maxExpressionDepth) + "id=1", "nested too deeply"},
+		{"length limit", "name='" + strings.Repeat("a", maxExpressionLength) + "'", "must not be more than 1000 bytes long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseExpression(tt.input, ItemFilterFields, time.UTC)
+
+			switch {
+			case err == nil:
+				t.Errorf("%s: expected an error containing %q", tt.input, tt.err)
+			case !strings.Contains(err.Error(), tt.err):
+				t.Errorf("%s: got error %q, want it to contain %q", tt.input, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseExpressionLimits(t *testing.T) {
+	deep := strings.Repeat("(", maxExpressionDepth-1) + "id=1" + strings.Repeat(")", maxExpressionDepth-1)
+	long := "name='" + strings.Repeat("a", maxExpressionLength-len("name=''")) + "'"
+
+	for _, input := range []string{deep, long} {
+		if _, err := ParseExpression(input, ItemFilterFields, time.UTC); err != nil {
+			t.Errorf("%.40s...: unexpected error %v", input, err)
+		}
+	}
+}
+
+func TestParseExpressionDates(t *testing.T) {
+	manila, err := time.LoadLocation("Asia/Manila")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+
+	tests := []struct {
+		input string
+		loc   *time.Location
+		want  time.Time
+	}{
+		{"created_at>=2024-03-01", time.UTC, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"created_at>=2024-03-01", manila, time.Date(2024, 2, 29, 16, 0, 0, 0, time.UTC)},
+		{"created_at>=2024-03-01T10:00:00Z", manila, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		e, err := ParseExpression(tt.input, ItemFilterFields, tt.loc)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.input, err)
+		}
+
+		var args queryArgs
+		e.sql(&args)
+		if got := args[0].(time.Time); !got.Equal(tt.want) {
+			t.Errorf("%s in %s: got %s, want %s", tt.input, tt.loc, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,14 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"test.com/internal/validator"
 )
@@ -12,14 +18,126 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	// Expression is the parsed filter query parameter, if any
+	Expression *Expression
+	// After is an opaque cursor taken from Metadata.NextCursor. When set, rows
+	// are paged by keyset from the cursor and Page is ignored.
+	After string
+	// IncludeTotal counts every matching row when paging by cursor, which
+	// otherwise is skipped as it costs a scan of the whole result.
+	IncludeTotal bool
+	// NoCursorSort lists the sort columns that can only be paged by page
+	// number, such as relevance or a nullable column.
+	NoCursorSort []string
+	// CursorFields gives the types of the sort columns and of id, which the
+	// values of a cursor are checked against. Columns it leaves out are taken
+	// to be a BIGINT id, a timestamp when named *_at and text otherwise.
+	CursorFields FilterFields
+}
+
+// cursor marks the last row of a page by its sort value and ID.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+
+	if err := json.Unmarshal(js, &c); err != nil {
+		return c, errInvalidCursor
+	}
+
+	return c, nil
+}
+
+// columnType returns the type of a sort column, or of id, for checking
+// cursors.
+func (f Filters) columnType(column string) FieldType {
+	if field, ok := f.CursorFields[column]; ok {
+		return field.Type
+	}
+
+	switch {
+	case column == "id":
+		return FieldBigInt
+	case strings.HasSuffix(column, "_at"):
+		return FieldTime
+	default:
+		return FieldText
+	}
+}
+
+// cursorValue returns the sort value of the cursor converted to the type of
+// the sort column, failing when it or the ID could not have come from a row,
+// as happens when a cursor is tampered with.
+func (f Filters) cursorValue(c cursor) (interface{}, error) {
+	idBits := 64
+	if f.columnType("id") == FieldInt {
+		idBits = 32
+	}
+	if c.ID < 1 || (idBits == 32 && c.ID > math.MaxInt32) {
+		return nil, errInvalidCursor
+	}
+
+	column := f.sortColumn()
+	if column == "id" {
+		return nil, nil
+	}
+
+	switch f.columnType(column) {
+	case FieldInt:
+		i, err := strconv.ParseInt(c.Value, 10, 32)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return i, nil
+	case FieldBigInt:
+		i, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return i, nil
+	case FieldTime:
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		return t, nil
+	default:
+		return c.Value, nil
+	}
+}
+
+// queryArgs collects the arguments of a dynamically built query and hands out
+// their placeholders, so user input never ends up in the SQL text.
+type queryArgs []interface{}
+
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
 }
 
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
@@ -36,6 +154,53 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	}
 }
 
+// paginate trims the look-ahead row fetched when paging by cursor and builds
+// the metadata, including the cursor of the next page when there is one.
+// sortValue returns the value of the sort column for a row, and its ID.
+func paginate[T any](rows []T, totalRecords int, f Filters, sortValue func(T, string) (interface{}, int64)) ([]T, Metadata) {
+	var metadata Metadata
+	hasMore := false
+
+	if f.After == "" {
+		metadata = calculateMetadata(totalRecords, f.Page, f.PageSize)
+		hasMore = f.offset()+len(rows) < totalRecords
+	} else {
+		if len(rows) > f.limit() {
+			rows = rows[:f.limit()]
+			hasMore = true
+		}
+		metadata = Metadata{PageSize: f.PageSize, TotalRecords: totalRecords}
+	}
+
+	if hasMore && len(rows) > 0 && f.cursorable() {
+		value, id := sortValue(rows[len(rows)-1], f.sortColumn())
+
+		c := cursor{Sort: f.Sort, ID: id}
+		switch value := value.(type) {
+		case time.Time:
+			c.Value = value.Format(time.RFC3339Nano)
+		default:
+			c.Value = fmt.Sprint(value)
+		}
+
+		metadata.NextCursor = encodeCursor(c)
+	}
+
+	return rows, metadata
+}
+
+// cursorable reports whether rows sorted by the sort column can be paged by
+// cursor.
+func (f Filters) cursorable() bool {
+	for _, column := range f.NoCursorSort {
+		if f.sortColumn() == column {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -54,6 +219,79 @@ func (f Filters) sortColumn() string {
 	panic("unsafe sort parameter: " + f.Sort)
 }
 
+// where returns the SQL condition of the filter expression, or TRUE when there
+// is none.
+func (f Filters) where(args *queryArgs) string {
+	if f.Expression == nil {
+		return "TRUE"
+	}
+
+	return f.Expression.sql(args)
+}
+
+// countColumn returns the select expression for the total number of rows
+// matching where in from, skipping the count when paging by cursor unless
+// IncludeTotal is set.
+func (f Filters) countColumn(from string, where string) string {
+	switch {
+	case f.After == "":
+		return "count(*) OVER()"
+	case f.IncludeTotal:
+		return "(SELECT count(*) FROM " + from + " WHERE " + where + ")"
+	default:
+		return "0"
+	}
+}
+
+// keyset returns the SQL condition selecting the rows of table after the
+// cursor, or TRUE when paging by page number.
+func (f Filters) keyset(table string, args *queryArgs) string {
+	if f.After == "" {
+		return "TRUE"
+	}
+
+	c, err := decodeCursor(f.After)
+	if err != nil {
+		panic("unvalidated cursor: " + f.After)
+	}
+
+	value, err := f.cursorValue(c)
+	if err != nil {
+		panic("unvalidated cursor: " + f.After)
+	}
+
+	operator := ">"
+	if f.sortDirection() == "DESC" {
+		operator = "<"
+	}
+
+	if f.sortColumn() == "id" {
+		return table + ".id " + operator + " " + args.add(c.ID)
+	}
+
+	return "(" + table + "." + f.sortColumn() + ", " + table + ".id) " + operator + " (" + args.add(value) + ", " + args.add(c.ID) + ")"
+}
+
+// orderBy sorts table by the sort column, breaking ties by ID so pages are
+// stable.
+func (f Filters) orderBy(table string) string {
+	if f.sortColumn() == "id" {
+		return table + ".id " + f.sortDirection()
+	}
+
+	return table + "." + f.sortColumn() + " " + f.sortDirection() + ", " + table + ".id " + f.sortDirection()
+}
+
+// limitOffset returns the LIMIT clause, fetching one row more than a page when
+// paging by cursor to tell whether another page follows.
+func (f Filters) limitOffset(args *queryArgs) string {
+	if f.After != "" {
+		return "LIMIT " + args.add(f.limit()+1)
+	}
+
+	return "LIMIT " + args.add(f.limit()) + " OFFSET " + args.add(f.offset())
+}
+
 func (f Filters) sortDirection() string {
 	if strings.HasPrefix(f.Sort, "-") {
 		return "DESC"
@@ -69,4 +307,18 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize <= 100, "page_size", "must be maximum of 100")
 
 	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+
+	if f.After != "" {
+		c, err := decodeCursor(f.After)
+		v.Check(err == nil, "after", "invalid cursor")
+		v.Check(err != nil || c.Sort == f.Sort, "after", "cursor does not match the sort order")
+
+		// The sort value is only known to be of the right type for a cursor
+		// that matches a safe sort
+		if err == nil && c.Sort == f.Sort && validator.In(f.Sort, f.SortSafelist...) {
+			_, err = f.cursorValue(c)
+			v.Check(err == nil, "after", "invalid cursor")
+		}
+		v.Check(!validator.In(f.Sort, f.SortSafelist...) || f.cursorable(), "after", "cursor pagination is not supported when sorting by "+strings.TrimPrefix(f.Sort, "-"))
+	}
 }
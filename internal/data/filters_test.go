@@ -0,0 +1,43 @@
+package data
+
+import (
+	"testing"
+
+	"test.com/internal/validator"
+)
+
+func TestValidateFiltersCursorValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  string
+		c     cursor
+		valid bool
+	}{
+		{"time", "created_at", cursor{Sort: "created_at", Value: "2026-01-02T03:04:05.5Z", ID: 7}, true},
+		{"bad time", "created_at", cursor{Sort: "created_at", Value: "yesterday", ID: 7}, false},
+		{"text", "-name", cursor{Sort: "-name", Value: "cable", ID: 7}, true},
+		{"id", "id", cursor{Sort: "id", ID: 7}, true},
+		{"oversized id", "id", cursor{Sort: "id", ID: 99999999999}, false},
+		{"negative id", "-id", cursor{Sort: "-id", ID: -1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Filters{
+				Page:         1,
+				PageSize:     10,
+				Sort:         tt.sort,
+				SortSafelist: []string{"id", "-id", "name", "-name", "created_at", "-created_at"},
+				CursorFields: ItemFilterFields,
+				After:        encodeCursor(tt.c),
+			}
+
+			v := validator.New()
+			ValidateFilters(v, f)
+
+			if v.Valid() != tt.valid {
+				t.Errorf("got valid %t, want %t (errors %v)", v.Valid(), tt.valid, v.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"test.com/internal/validator"
+)
+
+// Forecast estimates when an item will run out of stock, based on the
+// average daily consumption (issues and removals) over a look-back window.
+type Forecast struct {
+	ItemID            int64      `json:"item_id"`
+	Name              string     `json:"name"`
+	Remaining         int32      `json:"remaining"`
+	WindowDays        int        `json:"window_days"`
+	Consumed          int64      `json:"consumed"`
+	DailyConsumption  float64    `json:"daily_consumption"`
+	DaysUntilStockout *float64   `json:"days_until_stockout"`
+	StockoutAt        *time.Time `json:"stockout_at"`
+}
+
+type ForecastModel struct {
+	DB *sql.DB
+}
+
+func ValidateForecastWindow(v *validator.Validator, window int) {
+	v.Check(window > 0, "window", "must be greater than zero")
+	v.Check(window <= 365, "window", "must be maximum of 365")
+}
+
+// consumptionCTE sums issued and removed quantities per item over the last
+// $1 days. Issues whose time is unknown are left out, as they cannot be placed
+// in the window.
+const consumptionCTE = `
+	WITH consumption AS (
+		SELECT item_id, SUM(quantity) AS consumed
+		FROM (
+			SELECT item_id, quantity FROM issues
+			WHERE NOT issued_at_unknown AND issued_at > NOW() - make_interval(days => $1)
+			UNION ALL
+			SELECT item_id, quantity FROM removals
+			WHERE removed_at > NOW() - make_interval(days => $1)
+		) AS movements
+		GROUP BY item_id
+	)`
+
+func (f *Forecast) calculate(now time.Time) {
+	f.DailyConsumption = float64(f.Consumed) / float64(f.WindowDays)
+	if f.DailyConsumption == 0 {
+		return
+	}
+
+	days := float64(f.Remaining) / f.DailyConsumption
+	stockoutAt := now.Add(time.Duration(days * float64(24*time.Hour)))
+
+	f.DaysUntilStockout = &days
+	f.StockoutAt = &stockoutAt
+}
+
+func (m ForecastModel) GetForItem(itemID int64, window int) (*Forecast, error) {
+	if itemID < 1 {
+		return nil, ErrNoRecord
+	}
+
+	query := consumptionCTE + `
+	SELECT items.id, items.name, items.remaining, COALESCE(consumption.consumed, 0)
+	FROM items
+	LEFT JOIN consumption ON consumption.item_id = items.id
+	WHERE items.id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	forecast := Forecast{WindowDays: window}
+
+	err := m.DB.QueryRowContext(ctx, query, window, itemID).Scan(
+		&forecast.ItemID,
+		&forecast.Name,
+		&forecast.Remaining,
+		&forecast.Consumed,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	forecast.calculate(time.Now())
+
+	return &forecast, nil
+}
+
+// GetRunningOut returns the items expected to run out within the given number
+// of days, soonest first. Items with no consumption in the window are never
+// included since they have no projected stockout.
+func (m ForecastModel) GetRunningOut(window int, within int, filters Filters) ([]*Forecast, Metadata, error) {
+	query := consumptionCTE + `
+	SELECT COUNT(*) OVER(), items.id, items.name, items.remaining, consumption.consumed
+	FROM items
+	INNER JOIN consumption ON consumption.item_id = items.id
+	WHERE consumption.consumed > 0
+	AND items.remaining::float8 * $1 / consumption.consumed <= $2
+	ORDER BY items.remaining::float8 * $1 / consumption.consumed ASC, items.id ASC
+	LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, window, within, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	totalRecords := 0
+	forecasts := []*Forecast{}
+
+	for rows.Next() {
+		forecast := Forecast{WindowDays: window}
+
+		err := rows.Scan(
+			&totalRecords,
+			&forecast.ItemID,
+			&forecast.Name,
+			&forecast.Remaining,
+			&forecast.Consumed,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		forecast.calculate(now)
+		forecasts = append(forecasts, &forecast)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return forecasts, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,147 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// FieldChange holds the value of a field before and after a change. Before is
+// null when the item was created and After is null when it was deleted.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// HistoryEntry records one change of an item, with the fields it changed.
+type HistoryEntry struct {
+	ID        int64                  `json:"id"`
+	ItemID    int64                  `json:"item_id"`
+	UserID    *int64                 `json:"user_id"`
+	UserName  string                 `json:"username,omitempty"`
+	Action    string                 `json:"action"`
+	Changes   map[string]FieldChange `json:"changes"`
+	ChangedAt time.Time              `json:"changed_at"`
+}
+
+type HistoryModel struct {
+	DB *sql.DB
+}
+
+// DiffItems returns the fields that differ between two versions of an item.
+// Either may be nil, for an item being created or deleted.
+func DiffItems(before, after *Item) map[string]FieldChange {
+	fields := func(item *Item) map[string]interface{} {
+		if item == nil {
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{
+			"name":      item.Name,
+			"quantity":  item.Quantity,
+			"remaining": item.Remaining,
+			"remarks":   item.Remarks,
+		}
+	}
+
+	old, new := fields(before), fields(after)
+	changes := make(map[string]FieldChange)
+
+	for _, name := range []string{"name", "quantity", "remaining", "remarks"} {
+		if old[name] != new[name] {
+			changes[name] = FieldChange{Before: old[name], After: new[name]}
+		}
+	}
+
+	return changes
+}
+
+// Insert records the entry as part of tx, so it is only kept if the change
+// itself is committed.
+func (m HistoryModel) Insert(tx *sql.Tx, entry *HistoryEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO item_history (item_id, user_id, action, changes)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, changed_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{entry.ItemID, entry.UserID, entry.Action, changes}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.ChangedAt)
+}
+
+// GetForItem lists the history of an item, including one that was deleted.
+func (m HistoryModel) GetForItem(itemID int64, filters Filters) ([]*HistoryEntry, Metadata, error) {
+	args := queryArgs{}
+
+	where := "item_history.item_id = " + args.add(itemID)
+
+	query := `
+		SELECT ` + filters.countColumn("item_history", where) + `, item_history.id, item_history.item_id, item_history.user_id, COALESCE(users.username, ''), item_history.action, item_history.changes, item_history.changed_at
+		FROM item_history
+		LEFT JOIN users ON users.id = item_history.user_id
+		WHERE ` + where + `
+		AND ` + filters.keyset("item_history", &args) + `
+		ORDER BY ` + filters.orderBy("item_history") + `
+		` + filters.limitOffset(&args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	entries := []*HistoryEntry{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var entry HistoryEntry
+		var changes []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.ItemID,
+			&entry.UserID,
+			&entry.UserName,
+			&entry.Action,
+			&changes,
+			&entry.ChangedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(changes, &entry.Changes)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	entries, metadata := paginate(entries, totalRecords, filters, historySortValue)
+
+	return entries, metadata, nil
+}
+
+func historySortValue(entry *HistoryEntry, column string) (interface{}, int64) {
+	if column == "changed_at" {
+		return entry.ChangedAt, entry.ID
+	}
+	return entry.ID, entry.ID
+}
@@ -0,0 +1,150 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"time"
+
+	"test.com/internal/validator"
+)
+
+// IdempotencyKey remembers the response to a request made with an
+// Idempotency-Key header, so a retried request can be answered without being
+// processed twice. Status is zero while the original request is in progress.
+// Token identifies the reservation, so a request that outlived its lease
+// cannot complete or release the reservation of the retry that claimed the
+// key after it.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	RequestHash []byte
+	Token       []byte
+	Status      int
+	Response    []byte
+	CreatedAt   time.Time
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(len(key) <= 255, "Idempotency-Key", "must not be more than 255 bytes long")
+}
+
+// Reserve claims the key for a new request. If the key was already used within
+// ttl, the stored key is returned instead and reserved is false. A key still in
+// progress after lease is taken to belong to a request that never finished, and
+// is claimed again. A reserved key is returned with the token to complete or
+// release it with.
+func (m IdempotencyModel) Reserve(userID int64, key string, requestHash []byte, ttl, lease time.Duration) (*IdempotencyKey, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+		AND (created_at < $3 OR (status IS NULL AND created_at < $4))`
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key, time.Now().Add(-ttl), time.Now().Add(-lease))
+	if err != nil {
+		return nil, false, err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, false, err
+	}
+
+	query = `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, token)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`
+
+	res, err := m.DB.ExecContext(ctx, query, userID, key, requestHash, token)
+	if err != nil {
+		return nil, false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if affected == 1 {
+		reserved := &IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: requestHash,
+			Token:       token,
+		}
+		return reserved, true, nil
+	}
+
+	query = `
+		SELECT user_id, key, request_hash, COALESCE(status, 0), COALESCE(response, ''), created_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+
+	var stored IdempotencyKey
+
+	err = m.DB.QueryRowContext(ctx, query, userID, key).Scan(
+		&stored.UserID,
+		&stored.Key,
+		&stored.RequestHash,
+		&stored.Status,
+		&stored.Response,
+		&stored.CreatedAt,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &stored, false, nil
+}
+
+// Complete stores the response to the request the key was reserved for. It
+// does nothing once the reservation was claimed again by another request.
+func (m IdempotencyModel) Complete(reserved *IdempotencyKey, status int, response []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status = $1, response = $2
+		WHERE user_id = $3 AND key = $4 AND token = $5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, response, reserved.UserID, reserved.Key, reserved.Token)
+	return err
+}
+
+// Release frees a reserved key whose request failed, so it can be retried. It
+// does nothing once the reservation was claimed again by another request.
+func (m IdempotencyModel) Release(reserved *IdempotencyKey) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2 AND token = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, reserved.UserID, reserved.Key, reserved.Token)
+	return err
+}
+
+// DeleteExpired removes keys older than ttl and returns how many were removed.
+func (m IdempotencyModel) DeleteExpired(ttl time.Duration) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, time.Now().Add(-ttl))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
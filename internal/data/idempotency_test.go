@@ -0,0 +1,52 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStaleReservationCannotComplete(t *testing.T) {
+	db := openTestDB(t)
+	m := IdempotencyModel{DB: db}
+
+	user := insertTestUser(t, db)
+	hash := []byte("hash")
+
+	first, reserved, err := m.Reserve(user.ID, t.Name(), hash, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reserved {
+		t.Fatal("expected the key to be reserved")
+	}
+
+	// A negative lease treats the first reservation as abandoned
+	second, reserved, err := m.Reserve(user.ID, t.Name(), hash, time.Hour, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reserved {
+		t.Fatal("expected the abandoned key to be reserved again")
+	}
+
+	if err := m.Complete(first, 201, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Release(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Complete(second, 200, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, reserved, err := m.Reserve(user.ID, t.Name(), hash, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved {
+		t.Fatal("expected the completed key to be returned")
+	}
+	if stored.Status != 200 || string(stored.Response) != "second" {
+		t.Errorf("got %d %q, want the response of the second reservation", stored.Status, stored.Response)
+	}
+}
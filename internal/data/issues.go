@@ -4,16 +4,28 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
+	"strings"
 	"time"
 )
 
+var ErrNotOnLoan = errors.New("models: issue is not an open loan")
+
+// Issue hands out stock. An issue with a due date is a loan, which is overdue
+// once the date passes without it being returned.
 type Issue struct {
 	ID       int64     `json:"id"`
 	ItemID   int64     `json:"item_id"`
+	ItemName string    `json:"item_name,omitempty"`
 	Quantity int32     `json:"quantity"`
 	IssuedTo string    `json:"issued_to"`
 	IssuedAt time.Time `json:"issued_at"`
+	// IssuedAtUnknown is set on issues saved before the time of an issue was
+	// recorded, whose IssuedAt is the zero time
+	IssuedAtUnknown bool `json:"issued_at_unknown,omitempty"`
+	// CreatedBy is the user who made the issue, nil once they are deleted
+	CreatedBy  *int64     `json:"created_by"`
+	DueAt      *time.Time `json:"due_at"`
+	ReturnedAt *time.Time `json:"returned_at"`
 }
 
 type IssueModel struct {
@@ -22,31 +34,90 @@ type IssueModel struct {
 
 func (m IssueModel) InsertIssue(tx *sql.Tx, issue *Issue) error {
 	query := `
-		INSERT INTO issues (item_id, quantity, issued_to, issued_at)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO issues (item_id, quantity, issued_to, created_by, due_at)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, issued_at`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return tx.QueryRowContext(ctx, query, issue.ItemID, issue.Quantity, issue.IssuedTo, issue.IssuedAt).Scan(
+	args := []interface{}{issue.ItemID, issue.Quantity, issue.IssuedTo, issue.CreatedBy, issue.DueAt}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(
+		&issue.ID,
+		&issue.IssuedAt,
+	)
+}
+
+// Return marks the loan as returned. It fails with ErrNotOnLoan when the issue
+// has no due date or was already returned.
+func (m IssueModel) Return(tx *sql.Tx, id int64) (*Issue, error) {
+	if id < 1 {
+		return nil, ErrNoRecord
+	}
+
+	query := `
+		UPDATE issues
+		SET returned_at = NOW()
+		WHERE id = $1 AND due_at IS NOT NULL AND returned_at IS NULL
+		RETURNING id, item_id, quantity, issued_to, issued_at, created_by, due_at, returned_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var issue Issue
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(
 		&issue.ID,
+		&issue.ItemID,
+		&issue.Quantity,
+		&issue.IssuedTo,
 		&issue.IssuedAt,
+		&issue.CreatedBy,
+		&issue.DueAt,
+		&issue.ReturnedAt,
 	)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM issues WHERE id = $1)`, id).Scan(&exists)
+		switch {
+		case err != nil:
+			return nil, err
+		case !exists:
+			return nil, ErrNoRecord
+		default:
+			return nil, ErrNotOnLoan
+		}
+	}
+
+	return &issue, nil
 }
 
-func (m IssueModel) GetIssues(itemID int64, filters Filters) ([]*Issue, Metadata, error) {
-	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, item_id, quantity, issued_to, issued_at
+// GetIssues lists the issues matching filter, each with the name of its item.
+func (m IssueModel) GetIssues(filter LedgerFilter, filters Filters) ([]*Issue, Metadata, error) {
+	args := queryArgs{}
+
+	conditions := append(filter.conditions("issues", "issued_at", &args), filters.where(&args))
+	where := strings.Join(conditions, " AND ")
+
+	query := `
+		SELECT ` + filters.countColumn("issues INNER JOIN items ON items.id = issues.item_id", where) + `, issues.id, issues.item_id, items.name, issues.quantity, issues.issued_to, issues.issued_at, issues.created_by,
+			issues.due_at, issues.returned_at, issues.issued_at_unknown
 		FROM issues
-		WHERE item_id = $1
-		ORDER BY %s %s
-		LIMIT %d OFFSET %d`, filters.sortColumn(), filters.sortDirection(), filters.limit(), filters.offset())
+		INNER JOIN items ON items.id = issues.item_id
+		WHERE ` + where + `
+		AND ` + filters.keyset("issues", &args) + `
+		ORDER BY ` + filters.orderBy("issues") + `
+		` + filters.limitOffset(&args)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, itemID)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -62,7 +133,7 @@ func (m IssueModel) GetIssues(itemID int64, filters Filters) ([]*Issue, Metadata
 
 	for rows.Next() {
 		var issue Issue
-		err := rows.Scan(&totalRecords, &issue.ID, &issue.ItemID, &issue.Quantity, &issue.IssuedTo, &issue.IssuedAt)
+		err := rows.Scan(&totalRecords, &issue.ID, &issue.ItemID, &issue.ItemName, &issue.Quantity, &issue.IssuedTo, &issue.IssuedAt, &issue.CreatedBy, &issue.DueAt, &issue.ReturnedAt, &issue.IssuedAtUnknown)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -73,7 +144,15 @@ func (m IssueModel) GetIssues(itemID int64, filters Filters) ([]*Issue, Metadata
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	issues, metadata := paginate(issues, totalRecords, filters, issueSortValue)
 
 	return issues, metadata, nil
 }
+
+func issueSortValue(issue *Issue, column string) (interface{}, int64) {
+	if column == "issued_at" {
+		return issue.IssuedAt, issue.ID
+	}
+
+	return issue.ID, issue.ID
+}
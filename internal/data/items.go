@@ -4,14 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
+	"strings"
 	"time"
 )
 
 var (
-	ErrNoRecord     = errors.New("models: no matching record found")
-	ErrInvalidInput = errors.New("models: invalid input")
-	ErrEditConflict = errors.New("models: edit conflict")
+	ErrNoRecord          = errors.New("models: no matching record found")
+	ErrInvalidInput      = errors.New("models: invalid input")
+	ErrEditConflict      = errors.New("models: edit conflict")
+	ErrInsufficientStock = errors.New("models: insufficient stock")
 )
 
 type Item struct {
@@ -81,55 +82,132 @@ func (m ItemModel) GetItem(id int64) (*Item, error) {
 	return &item, nil
 }
 
-func (m ItemModel) GetAllItems(name string, remarks string, tagId int, filters Filters) ([]*Item, Metadata, error) {
-	// query := `
-	// 	SELECT count(*) OVER(), id, name,  quantity, remaining, remarks, created_at, version
-	// 	FROM items
-	// 	INNER JOIN item_tags ON item_tags.item_id = items.id
-	// 	AND item_tags.id = $1
-	// 	WHERE (LOWER(name) = LOWER($2) OR $2 = '')
-	// 	AND (remarks ILIKE '%' || $3 || '%' OR $3 = '')
-	// 	ORDER BY items.` + filters.sortColumn() + ` ` + filters.sortDirection() + `
-	// 	LIMIT $4 OFFSET $5`
+// remainingAsOf reconstructs items.remaining at the time bound to the given
+// placeholder by reverting every ledger movement recorded after it. Issues
+// whose time is unknown cannot be placed before or after it and are left out,
+// so stock as of a time before such an issue is short by its quantity.
+func remainingAsOf(placeholder string) string {
+	return `items.remaining
+		- COALESCE((SELECT SUM(quantity) FROM additions WHERE additions.item_id = items.id AND additions.added_at > ` + placeholder + `), 0)
+		+ COALESCE((SELECT SUM(quantity) FROM issues WHERE issues.item_id = items.id AND NOT issues.issued_at_unknown AND issues.issued_at > ` + placeholder + `), 0)
+		+ COALESCE((SELECT SUM(quantity) FROM removals WHERE removals.item_id = items.id AND removals.removed_at > ` + placeholder + `), 0)
+		- COALESCE((SELECT SUM(quantity) FROM adjustments WHERE adjustments.item_id = items.id AND adjustments.adjusted_at > ` + placeholder + `), 0)`
+}
+
+// GetItemAsOf returns the item with its remaining stock as it stood at asOf.
+func (m ItemModel) GetItemAsOf(id int64, asOf time.Time) (*Item, error) {
+	if id < 1 {
+		return nil, ErrNoRecord
+	}
 
-	// ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	// defer cancel()
+	query := `
+		SELECT id, name, quantity, ` + remainingAsOf("$2") + `, remarks, created_at, version
+		FROM items
+		WHERE id = $1 AND created_at <= $2`
 
-	// args := []interface{}{tagId, name, remarks, filters.limit(), filters.offset()}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	// rows, err := m.DB.QueryContext(ctx, query, args...)
+	var item Item
 
-	// from AI
-	query := `
-	SELECT count(*) OVER(), items.id, items.name, items.quantity, items.remaining, items.remarks, items.created_at, items.version
-	FROM items`
-
-	args := []interface{}{}
-	argIndex := 1 // PostgreSQL placeholders start with $1
-
-	if tagId != 0 {
-		query += `
-	INNER JOIN item_tags ON item_tags.item_id = items.id
-	AND item_tags.tag_id = $` + fmt.Sprint(argIndex)
-		args = append(args, tagId)
-		argIndex++
+	err := m.DB.QueryRowContext(ctx, query, id, asOf).Scan(
+		&item.ID,
+		&item.Name,
+		&item.Quantity,
+		&item.Remaining,
+		&item.Remarks,
+		&item.CreatedAt,
+		&item.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return &item, nil
+}
+
+// searchMatch matches items against the search text bound to the given
+// placeholder using full-text search over name, remarks and tag names, falling
+// back to trigram similarity on the name to tolerate typos.
+func searchMatch(placeholder string) string {
+	return `(items.search @@ websearch_to_tsquery('english', ` + placeholder + `)
+		OR ` + placeholder + ` <% items.name
+		OR EXISTS (
+			SELECT 1 FROM item_tags
+			INNER JOIN tags ON tags.id = item_tags.tag_id
+			WHERE item_tags.item_id = items.id
+			AND to_tsvector('english', tags.name) @@ websearch_to_tsquery('english', ` + placeholder + `)))`
+}
+
+// searchRank scores how well an item matches the search text bound to the
+// given placeholder.
+func searchRank(placeholder string) string {
+	return `ts_rank(items.search, websearch_to_tsquery('english', ` + placeholder + `)) + word_similarity(` + placeholder + `, items.name)`
+}
+
+// ItemFilter narrows the items returned by GetAllItems.
+type ItemFilter struct {
+	Name    string
+	Remarks string
+	// Search restricts the list to items relevant to the text, which can then
+	// be sorted by "relevance".
+	Search string
+	Tags   TagFilter
+	// A non-zero AsOf lists the items that existed at that time, with their
+	// stock as it stood then.
+	AsOf time.Time
+}
+
+func (m ItemModel) GetAllItems(filter ItemFilter, filters Filters) ([]*Item, Metadata, error) {
+	args := queryArgs{}
+
+	remaining := "items.remaining"
+	asOf := ""
+	if !filter.AsOf.IsZero() {
+		asOf = args.add(filter.AsOf)
+		remaining = remainingAsOf(asOf)
+	}
+
+	name := args.add(filter.Name)
+	remarks := args.add(filter.Remarks)
+
+	conditions := []string{
+		`(items.name ILIKE '%' || ` + name + ` || '%' OR ` + name + ` = '')`,
+		`(items.remarks ILIKE '%' || ` + remarks + ` || '%' OR ` + remarks + ` = '')`,
+		filters.where(&args),
+	}
+
+	if asOf != "" {
+		conditions = append(conditions, "items.created_at <= "+asOf)
 	}
 
-	query += `
-	WHERE (name ILIKE '%' || $` + fmt.Sprint(argIndex) + ` || '%' OR $` + fmt.Sprint(argIndex) + ` = '')`
-	args = append(args, name)
-	argIndex++
+	conditions = append(conditions, filter.Tags.conditions("items.id", &args)...)
+
+	orderBy := filters.orderBy("items")
 
-	query += `
-	AND (remarks ILIKE '%' || $` + fmt.Sprint(argIndex) + ` || '%' OR $` + fmt.Sprint(argIndex) + ` = '')`
-	args = append(args, remarks)
-	argIndex++
+	if filter.Search != "" {
+		search := args.add(filter.Search)
+		conditions = append(conditions, searchMatch(search))
 
-	query += `
-	ORDER BY ` + filters.sortColumn() + ` ` + filters.sortDirection() + `
-	LIMIT $` + fmt.Sprint(argIndex) + ` OFFSET $` + fmt.Sprint(argIndex+1)
+		if filters.sortColumn() == "relevance" {
+			orderBy = searchRank(search) + " DESC, items.id ASC"
+		}
+	}
 
-	args = append(args, filters.limit(), filters.offset())
+	where := strings.Join(conditions, "\n\tAND ")
+
+	query := `
+	SELECT ` + filters.countColumn("items", where) + `, items.id, items.name, items.quantity, ` + remaining + `, items.remarks, items.created_at, items.version
+	FROM items
+	WHERE ` + where + `
+	AND ` + filters.keyset("items", &args) + `
+	ORDER BY ` + orderBy + `
+	` + filters.limitOffset(&args)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -173,75 +251,150 @@ func (m ItemModel) GetAllItems(name string, remarks string, tagId int, filters F
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	items, metadata := paginate(items, totalRecords, filters, itemSortValue)
 
 	return items, metadata, nil
 }
 
-func (m ItemModel) UpdateRemaining(tx *sql.Tx, id int64, removed int32, version int32) error {
+func itemSortValue(item *Item, column string) (interface{}, int64) {
+	switch column {
+	case "name":
+		return item.Name, item.ID
+	case "remarks":
+		return item.Remarks, item.ID
+	case "created_at":
+		return item.CreatedAt, item.ID
+	default:
+		return item.ID, item.ID
+	}
+}
+
+// UpdateRemaining takes removed out of the item's stock in a single
+// conditional statement, so concurrent decrements neither conflict nor take
+// remaining below zero. It returns the item as updated, or as it stands along
+// with ErrInsufficientStock when there is not enough stock.
+func (m ItemModel) UpdateRemaining(tx *sql.Tx, id int64, removed int32) (*Item, error) {
 	if removed < 0 {
-		return ErrInvalidInput
+		return nil, ErrInvalidInput
 	}
 
 	query := `
 		UPDATE items
 		SET remaining = remaining - $1, version = version + 1
-		WHERE id = $2 AND version = $3
-		RETURNING version`
+		WHERE id = $2 AND remaining >= $1
+		RETURNING id, name, quantity, remaining, remarks, created_at, version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := tx.QueryRowContext(ctx, query, removed, id, version).Scan(&version)
+	var item Item
+
+	err := tx.QueryRowContext(ctx, query, removed, id).Scan(
+		&item.ID,
+		&item.Name,
+		&item.Quantity,
+		&item.Remaining,
+		&item.Remarks,
+		&item.CreatedAt,
+		&item.Version,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			// Either the item does not exist or it has too little stock
+			current, err := m.getItem(tx, id)
+			if err != nil {
+				return nil, err
+			}
+			return current, ErrInsufficientStock
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return &item, nil
 }
 
-func (m ItemModel) DeleteItem(id int64) error {
-	if id < 1 {
-		return ErrNoRecord
-	}
-
+// getItem reads the item inside the transaction.
+func (m ItemModel) getItem(tx *sql.Tx, id int64) (*Item, error) {
 	query := `
-		DELETE FROM items
+		SELECT id, name, quantity, remaining, remarks, created_at, version
+		FROM items
 		WHERE id = $1`
 
-	_, err := m.DB.Exec(query, id)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var item Item
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(
+		&item.ID,
+		&item.Name,
+		&item.Quantity,
+		&item.Remaining,
+		&item.Remarks,
+		&item.CreatedAt,
+		&item.Version,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrNoRecord
+			return nil, ErrNoRecord
 		default:
-			return err
+			return nil, err
 		}
 	}
 
+	return &item, nil
+}
+
+// DeleteItem deletes the item if it is still at the given version.
+func (m ItemModel) DeleteItem(tx *sql.Tx, id int64, version int32) error {
+	if id < 1 {
+		return ErrNoRecord
+	}
+
+	query := `
+		DELETE FROM items
+		WHERE id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, id, version)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrEditConflict
+	}
+
 	return nil
 }
 
-func (m ItemModel) UpdateItem(item *Item) error {
+// UpdateItem saves every editable field of the item, provided it is still at
+// item.Version, and moves item.Version on.
+func (m ItemModel) UpdateItem(tx *sql.Tx, item *Item) error {
 	if item.ID < 1 {
 		return ErrNoRecord
 	}
 
 	query := `
 		UPDATE items
-		SET  remaining = $1, version = version + 1
-		WHERE id = $2 AND version = $3
+		SET name = $1, quantity = $2, remaining = $3, remarks = $4, version = version + 1
+		WHERE id = $5 AND version = $6
 		RETURNING version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, item.Remaining, item.ID, item.Version).Scan(&item.Version)
+	args := []interface{}{item.Name, item.Quantity, item.Remaining, item.Remarks, item.ID, item.Version}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&item.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -254,29 +407,41 @@ func (m ItemModel) UpdateItem(item *Item) error {
 	return nil
 }
 
-func (m ItemModel) AddRemaining(tx *sql.Tx, id int64, removed int32, version int32) error {
-	if removed < 0 {
-		return ErrInvalidInput
+// AddRemaining puts added into the item's stock and returns the item as
+// updated.
+func (m ItemModel) AddRemaining(tx *sql.Tx, id int64, added int32) (*Item, error) {
+	if added < 0 {
+		return nil, ErrInvalidInput
 	}
 
 	query := `
 		UPDATE items
 		SET remaining = remaining + $1, version = version + 1
-		WHERE id = $2 AND version = $3
-		RETURNING version`
+		WHERE id = $2
+		RETURNING id, name, quantity, remaining, remarks, created_at, version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := tx.QueryRowContext(ctx, query, removed, id, version).Scan(&version)
+	var item Item
+
+	err := tx.QueryRowContext(ctx, query, added, id).Scan(
+		&item.ID,
+		&item.Name,
+		&item.Quantity,
+		&item.Remaining,
+		&item.Remarks,
+		&item.CreatedAt,
+		&item.Version,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return nil, ErrNoRecord
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return &item, nil
 }
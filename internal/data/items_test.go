@@ -0,0 +1,168 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// openTestDB connects to the migrated database in TEST_DB_DSN, skipping the
+// test when it is not set.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// inTestTx runs fn in a transaction and commits it, failing the test if
+// either fails.
+func inTestTx(t *testing.T, db *sql.DB, fn func(tx *sql.Tx) error) {
+	t.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func insertTestItem(t *testing.T, db *sql.DB, quantity int32) *Item {
+	t.Helper()
+
+	m := ItemModel{DB: db}
+	item := &Item{Name: t.Name(), Quantity: quantity}
+
+	inTestTx(t, db, func(tx *sql.Tx) error { return m.InsertItem(tx, item) })
+
+	t.Cleanup(func() { db.Exec("DELETE FROM items WHERE id = $1", item.ID) })
+
+	return item
+}
+
+// runConcurrentTxs starts workers transactions at the same moment, each running
+// fn, and commits those for which fn succeeds. It returns how many committed
+// and how many fn rejected with the expected error; any other error fails the
+// test.
+func runConcurrentTxs(t *testing.T, db *sql.DB, workers int, expected error, fn func(tx *sql.Tx) error) (committed, rejected int) {
+	t.Helper()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	start := make(chan struct{})
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer tx.Rollback()
+
+			err = fn(tx)
+			switch {
+			case errors.Is(err, expected):
+				mu.Lock()
+				rejected++
+				mu.Unlock()
+				return
+			case err != nil:
+				t.Error(err)
+				return
+			}
+
+			if err := tx.Commit(); err != nil {
+				t.Error(err)
+				return
+			}
+
+			mu.Lock()
+			committed++
+			mu.Unlock()
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	return committed, rejected
+}
+
+func TestUpdateRemainingConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	m := ItemModel{DB: db}
+
+	const (
+		stock    = 7
+		workers  = 20
+		quantity = 2
+	)
+
+	item := insertTestItem(t, db, stock)
+
+	succeeded, insufficient := runConcurrentTxs(t, db, workers, ErrInsufficientStock, func(tx *sql.Tx) error {
+		_, err := m.UpdateRemaining(tx, item.ID, quantity)
+		return err
+	})
+
+	if want := stock / quantity; succeeded != want {
+		t.Errorf("succeeded = %d; want %d", succeeded, want)
+	}
+	if want := workers - stock/quantity; insufficient != want {
+		t.Errorf("insufficient = %d; want %d", insufficient, want)
+	}
+
+	got, err := m.GetItem(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int32(stock % quantity); got.Remaining != want {
+		t.Errorf("remaining = %d; want %d", got.Remaining, want)
+	}
+}
+
+func TestRemainingCannotGoNegative(t *testing.T) {
+	db := openTestDB(t)
+
+	item := insertTestItem(t, db, 1)
+
+	_, err := db.Exec("UPDATE items SET remaining = -1 WHERE id = $1", item.ID)
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code.Name() != "check_violation" {
+		t.Fatalf("err = %v; want a check_violation", err)
+	}
+}
@@ -0,0 +1,167 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// JobRun records one run of a scheduled job, for the time it was scheduled for.
+type JobRun struct {
+	ID           int64      `json:"id"`
+	Job          string     `json:"job"`
+	Status       string     `json:"status"`
+	Error        string     `json:"error,omitempty"`
+	ScheduledFor *time.Time `json:"scheduled_for"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+}
+
+type JobRunModel struct {
+	DB *sql.DB
+}
+
+// TryLock takes the Postgres advisory lock for the job, so that when several
+// replicas are running only one of them runs it. It returns false if another
+// session holds the lock. The lock belongs to a connection of its own, which
+// unlock releases.
+func (m JobRunModel) TryLock(job string) (unlock func(), locked bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, "job:"+job).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		return nil, false, err
+	}
+
+	unlock = func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, "job:"+job)
+		conn.Close()
+	}
+
+	return unlock, true, nil
+}
+
+// Start records that the job has started running for the time it was
+// scheduled for. If that run has already been recorded, by this or another
+// replica, started is false and the job should not be run.
+func (m JobRunModel) Start(job string, scheduledFor time.Time) (run *JobRun, started bool, err error) {
+	query := `
+		INSERT INTO job_runs (job, scheduled_for)
+		VALUES ($1, $2)
+		ON CONFLICT (job, scheduled_for) DO NOTHING
+		RETURNING id, status, started_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	run = &JobRun{Job: job, ScheduledFor: &scheduledFor}
+
+	err = m.DB.QueryRowContext(ctx, query, job, scheduledFor).Scan(&run.ID, &run.Status, &run.StartedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, false, nil
+		default:
+			return nil, false, err
+		}
+	}
+
+	return run, true, nil
+}
+
+// Finish records the outcome of the run, which failed if runErr is not nil.
+func (m JobRunModel) Finish(run *JobRun, runErr error) error {
+	run.Status = JobSucceeded
+	if runErr != nil {
+		run.Status = JobFailed
+		run.Error = runErr.Error()
+	}
+
+	query := `
+		UPDATE job_runs
+		SET status = $1, error = $2, finished_at = NOW()
+		WHERE id = $3
+		RETURNING finished_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, run.Status, run.Error, run.ID).Scan(&run.FinishedAt)
+}
+
+// GetAll lists the runs of every job, or of one job if job is not empty.
+func (m JobRunModel) GetAll(job string, filters Filters) ([]*JobRun, Metadata, error) {
+	args := queryArgs{}
+
+	where := "TRUE"
+	if job != "" {
+		where = "job_runs.job = " + args.add(job)
+	}
+
+	query := `
+		SELECT ` + filters.countColumn("job_runs", where) + `, id, job, status, error, scheduled_for, started_at, finished_at
+		FROM job_runs
+		WHERE ` + where + `
+		AND ` + filters.keyset("job_runs", &args) + `
+		ORDER BY ` + filters.orderBy("job_runs") + `
+		` + filters.limitOffset(&args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	runs := []*JobRun{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var run JobRun
+
+		err := rows.Scan(
+			&totalRecords,
+			&run.ID,
+			&run.Job,
+			&run.Status,
+			&run.Error,
+			&run.ScheduledFor,
+			&run.StartedAt,
+			&run.FinishedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		runs = append(runs, &run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	runs, metadata := paginate(runs, totalRecords, filters, func(run *JobRun, column string) (interface{}, int64) {
+		return run.ID, run.ID
+	})
+
+	return runs, metadata, nil
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"time"
+
+	"test.com/internal/validator"
+)
+
+// LedgerFilter narrows the issues, removals and additions returned by the
+// ledger lists. Zero values leave a field unfiltered.
+type LedgerFilter struct {
+	ItemID   int64
+	ItemName string
+	From     time.Time
+	To       time.Time
+	// IssuedTo only applies to issues
+	IssuedTo    string
+	MinQuantity int
+	MaxQuantity int
+	Tags        TagFilter
+	CreatedBy   int64
+}
+
+func ValidateLedgerFilter(v *validator.Validator, f LedgerFilter, tagMode string) {
+	v.Check(f.ItemID >= 0, "item_id", "must not be negative")
+	v.Check(f.CreatedBy >= 0, "created_by", "must not be negative")
+	v.Check(f.MinQuantity >= 0, "min_quantity", "must not be negative")
+	v.Check(f.MaxQuantity >= 0, "max_quantity", "must not be negative")
+	v.Check(f.MaxQuantity == 0 || f.MinQuantity <= f.MaxQuantity, "max_quantity", "must not be less than min_quantity")
+	v.Check(f.From.IsZero() || f.To.IsZero() || !f.To.Before(f.From), "to", "must not be before from")
+
+	ValidateTagFilter(v, f.Tags, tagMode)
+}
+
+// conditions returns the SQL conditions matching the rows of table, which is
+// joined with items, against the filter. timeColumn is when a row was recorded.
+func (f LedgerFilter) conditions(table string, timeColumn string, args *queryArgs) []string {
+	conditions := []string{}
+
+	if f.ItemID != 0 {
+		conditions = append(conditions, table+".item_id = "+args.add(f.ItemID))
+	}
+
+	if f.ItemName != "" {
+		conditions = append(conditions, `items.name ILIKE '%' || `+args.add(escapeLike(f.ItemName))+` || '%'`)
+	}
+
+	if !f.From.IsZero() {
+		conditions = append(conditions, table+"."+timeColumn+" >= "+args.add(f.From))
+	}
+
+	if !f.To.IsZero() {
+		conditions = append(conditions, table+"."+timeColumn+" <= "+args.add(f.To))
+	}
+
+	if f.IssuedTo != "" && table == "issues" {
+		conditions = append(conditions, `issues.issued_to ILIKE '%' || `+args.add(escapeLike(f.IssuedTo))+` || '%'`)
+	}
+
+	if f.CreatedBy != 0 {
+		conditions = append(conditions, table+".created_by = "+args.add(f.CreatedBy))
+	}
+
+	if f.MinQuantity != 0 {
+		conditions = append(conditions, table+".quantity >= "+args.add(f.MinQuantity))
+	}
+
+	if f.MaxQuantity != 0 {
+		conditions = append(conditions, table+".quantity <= "+args.add(f.MaxQuantity))
+	}
+
+	return append(conditions, f.Tags.conditions(table+".item_id", args)...)
+}
@@ -0,0 +1,343 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	OutboxPending    = "pending"
+	OutboxDispatched = "dispatched"
+	OutboxFailed     = "failed"
+)
+
+// OutboxChannel is notified with the ID of every event saved to the outbox,
+// when the transaction that saved it commits.
+const OutboxChannel = "outbox"
+
+// OutboxEvent is a domain event saved in the same transaction as the change
+// that caused it, to be dispatched once the transaction has committed.
+type OutboxEvent struct {
+	ID     int64
+	Event  string
+	ItemID int64
+	// Tags are the names of the item's tags when the event happened
+	Tags      []string
+	Payload   json.RawMessage
+	Attempts  int
+	CreatedAt time.Time
+	// Position orders events by when they committed, and is only set on
+	// events read with GetAfter
+	Position int64
+}
+
+// Message is the event as it is sent on: its ID, type and time, with the
+// payload as data. The ID stays the same if the event is dispatched again, so
+// receivers can discard duplicates.
+func (e *OutboxEvent) Message() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":          e.ID,
+		"event":       e.Event,
+		"occurred_at": e.CreatedAt,
+		"data":        e.Payload,
+	})
+}
+
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// Insert saves the event in the transaction, along with the item's tags, so
+// that it is dispatched if and only if the transaction commits.
+func (m OutboxModel) Insert(tx *sql.Tx, event string, itemID int64, payload interface{}) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		WITH inserted AS (
+			INSERT INTO outbox (event, item_id, payload, tags)
+			VALUES ($1, $2, $3, ARRAY(
+				SELECT tags.name FROM item_tags
+				INNER JOIN tags ON tags.id = item_tags.tag_id
+				WHERE item_tags.item_id = $2
+				ORDER BY tags.name))
+			RETURNING id
+		)
+		SELECT pg_notify($4, id::text) FROM inserted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = tx.ExecContext(ctx, query, event, itemID, js, OutboxChannel)
+	return err
+}
+
+const outboxColumns = `id, event, item_id, tags, payload, attempts, created_at`
+
+func scanOutboxEvent(row interface{ Scan(...interface{}) error }) (*OutboxEvent, error) {
+	var event OutboxEvent
+	var payload []byte
+
+	err := row.Scan(&event.ID, &event.Event, &event.ItemID, pq.Array(&event.Tags), &payload, &event.Attempts, &event.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	event.Payload = json.RawMessage(payload)
+
+	return &event, nil
+}
+
+func scanOutboxEvents(rows *sql.Rows) ([]*OutboxEvent, error) {
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+
+	for rows.Next() {
+		event, err := scanOutboxEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetPending locks and returns up to limit events that are due to be
+// dispatched, oldest first. Events locked by another transaction are skipped,
+// so that each is only dispatched by one replica at a time.
+func (m OutboxModel) GetPending(tx *sql.Tx, limit int) ([]*OutboxEvent, error) {
+	query := `
+		SELECT ` + outboxColumns + `
+		FROM outbox
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanOutboxEvents(rows)
+}
+
+// Sequence gives a position to every committed event that has none. Batches
+// are sequenced one at a time, each after the last has committed, so an event
+// never gets a position below one that can already be read.
+func (m OutboxModel) Sequence() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "outbox_positions")
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO outbox_positions (event_id)
+		SELECT id FROM outbox
+		WHERE NOT EXISTS (SELECT 1 FROM outbox_positions WHERE outbox_positions.event_id = outbox.id)
+		ORDER BY id`
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAfter returns up to limit of the sequenced events after position
+// afterPosition, in order, whatever their dispatch status. Events are kept for
+// a while after being dispatched, so that they can be read again.
+func (m OutboxModel) GetAfter(afterPosition int64, limit int) ([]*OutboxEvent, error) {
+	query := `
+		SELECT outbox_positions.position, ` + outboxColumns + `
+		FROM outbox_positions
+		INNER JOIN outbox ON outbox.id = outbox_positions.event_id
+		WHERE outbox_positions.position > $1
+		ORDER BY outbox_positions.position
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, afterPosition, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+
+	for rows.Next() {
+		var event OutboxEvent
+		var payload []byte
+
+		err := rows.Scan(&event.Position, &event.ID, &event.Event, &event.ItemID, pq.Array(&event.Tags), &payload, &event.Attempts, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Payload = json.RawMessage(payload)
+		events = append(events, &event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Positions returns the newest position given to an event, and the newest
+// whose event has since been removed. Reading on from a position below pruned
+// misses events.
+func (m OutboxModel) Positions() (latest, pruned int64, err error) {
+	query := `
+		SELECT COALESCE((SELECT MAX(position) FROM outbox_positions), 0), pruned_through
+		FROM outbox_pruned`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query).Scan(&latest, &pruned)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if pruned > latest {
+		latest = pruned
+	}
+
+	return latest, pruned, nil
+}
+
+// GetDeliveredSinks returns the names of the sinks that have taken the event,
+// which are not given it again when it is retried.
+func (m OutboxModel) GetDeliveredSinks(tx *sql.Tx, eventID int64) (map[string]bool, error) {
+	query := `
+		SELECT sink
+		FROM outbox_deliveries
+		WHERE event_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, query, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sinks := make(map[string]bool)
+
+	for rows.Next() {
+		var sink string
+
+		if err := rows.Scan(&sink); err != nil {
+			return nil, err
+		}
+
+		sinks[sink] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sinks, nil
+}
+
+// RecordDelivery records that the sink has taken the event, in the transaction
+// the sink took it in.
+func (m OutboxModel) RecordDelivery(tx *sql.Tx, eventID int64, sink string) error {
+	query := `
+		INSERT INTO outbox_deliveries (event_id, sink)
+		VALUES ($1, $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, eventID, sink)
+	return err
+}
+
+// MarkDispatched records that every sink has taken the event.
+func (m OutboxModel) MarkDispatched(tx *sql.Tx, id int64) error {
+	query := `
+		UPDATE outbox
+		SET status = 'dispatched', attempts = attempts + 1, last_error = '', dispatched_at = NOW()
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, id)
+	return err
+}
+
+// RecordFailure records a failed attempt to dispatch the event. It is tried
+// again at retryAt, or given up on if status is failed.
+func (m OutboxModel) RecordFailure(tx *sql.Tx, id int64, status string, retryAt time.Time, dispatchErr error) error {
+	query := `
+		UPDATE outbox
+		SET status = $1, attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+		WHERE id = $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, status, retryAt, dispatchErr.Error(), id)
+	return err
+}
+
+// DeleteDispatched removes events dispatched more than age ago and returns how
+// many were removed. Their positions are removed with them, and the newest of
+// those is kept so that a stream resuming from before it can be reset.
+func (m OutboxModel) DeleteDispatched(age time.Duration) (int64, error) {
+	query := `
+		WITH deleted AS (
+			DELETE FROM outbox
+			WHERE status = 'dispatched' AND dispatched_at < $1
+			RETURNING id
+		), positions AS (
+			DELETE FROM outbox_positions
+			WHERE event_id IN (SELECT id FROM deleted)
+			RETURNING position
+		), pruned AS (
+			UPDATE outbox_pruned
+			SET pruned_through = GREATEST(pruned_through, (SELECT COALESCE(MAX(position), 0) FROM positions))
+		)
+		SELECT COUNT(*) FROM deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deleted int64
+	err := m.DB.QueryRowContext(ctx, query, time.Now().Add(-age)).Scan(&deleted)
+	return deleted, err
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestOutboxPositionsFollowCommitOrder(t *testing.T) {
+	db := openTestDB(t)
+	m := OutboxModel{DB: db}
+
+	item := insertTestItem(t, db, 1)
+
+	if err := m.Sequence(); err != nil {
+		t.Fatal(err)
+	}
+	start, _, err := m.Positions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The slow transaction saves its event first, so it has the lower ID, but
+	// commits last
+	slow, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Rollback()
+
+	if err := m.Insert(slow, "slow", item.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fast, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fast.Rollback()
+
+	if err := m.Insert(fast, "fast", item.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fast.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM outbox_positions WHERE event_id IN (SELECT id FROM outbox WHERE item_id = $1)", item.ID)
+		db.Exec("DELETE FROM outbox WHERE item_id = $1", item.ID)
+	})
+
+	if err := m.Sequence(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := outboxEventsFor(t, m, start, item.ID)
+	if len(events) != 1 || events[0].Event != "fast" {
+		t.Fatalf("got %d events; want the committed one only", len(events))
+	}
+
+	if err := slow.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Sequence(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading on from the event already read must not miss the one that
+	// committed after it
+	events = outboxEventsFor(t, m, events[0].Position, item.ID)
+	if len(events) != 1 || events[0].Event != "slow" {
+		t.Fatalf("got %d events; want the one committed last", len(events))
+	}
+}
+
+// outboxEventsFor reads on from the position, keeping the events of the item
+// only, as other tests may be saving events at the same time.
+func outboxEventsFor(t *testing.T, m OutboxModel, after int64, itemID int64) []*OutboxEvent {
+	t.Helper()
+
+	all, err := m.GetAfter(after, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []*OutboxEvent{}
+	for _, e := range all {
+		if e.ItemID == itemID {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
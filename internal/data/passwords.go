@@ -0,0 +1,112 @@
+package data
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"test.com/internal/validator"
+)
+
+// bcrypt ignores everything after the first 72 bytes of a password.
+const MaxPasswordBytes = 72
+
+//go:embed "common_passwords.txt"
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	passwords := make(map[string]bool)
+	addCommonPasswords(passwords, strings.NewReader(commonPasswordList))
+	return passwords
+}()
+
+// addCommonPasswords adds the passwords listed one per line in r, skipping
+// blank lines and lines starting with #.
+func addCommonPasswords(passwords map[string]bool, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		password := strings.TrimSpace(scanner.Text())
+		if password == "" || strings.HasPrefix(password, "#") {
+			continue
+		}
+		passwords[strings.ToLower(password)] = true
+	}
+
+	return scanner.Err()
+}
+
+// LoadCommonPasswords adds the passwords listed in the file, one per line, to
+// the bundled list of common passwords. It must be called before any password
+// is validated.
+func LoadCommonPasswords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return addCommonPasswords(commonPasswords, f)
+}
+
+// PasswordPolicy is what a new password must satisfy. Lengths count
+// characters rather than bytes.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	RejectCommon  bool
+}
+
+// Check reports whether the policy itself makes sense.
+func (p PasswordPolicy) Check() error {
+	switch {
+	case p.MinLength < 1:
+		return errors.New("password policy: minimum length must be at least 1")
+	case p.MaxLength < p.MinLength:
+		return errors.New("password policy: maximum length must not be less than the minimum length")
+	case p.MaxLength > MaxPasswordBytes:
+		return fmt.Errorf("password policy: maximum length must not be more than %d", MaxPasswordBytes)
+	}
+
+	return nil
+}
+
+// ValidatePassword checks a new password against the policy.
+func ValidatePassword(v *validator.Validator, key, password string, policy PasswordPolicy) {
+	length := utf8.RuneCountInString(password)
+
+	v.Check(password != "", key, "must be provided")
+	v.Check(length >= policy.MinLength, key, fmt.Sprintf("must be at least %d characters long", policy.MinLength))
+	v.Check(length <= policy.MaxLength, key, fmt.Sprintf("must not be more than %d characters long", policy.MaxLength))
+	v.Check(len(password) <= MaxPasswordBytes, key, fmt.Sprintf("must not be more than %d bytes long", MaxPasswordBytes))
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	v.Check(!policy.RequireUpper || upper, key, "must contain an upper case letter")
+	v.Check(!policy.RequireLower || lower, key, "must contain a lower case letter")
+	v.Check(!policy.RequireDigit || digit, key, "must contain a digit")
+	v.Check(!policy.RequireSymbol || symbol, key, "must contain a symbol")
+
+	v.Check(!policy.RejectCommon || !commonPasswords[strings.ToLower(password)], key, "is too common")
+}
@@ -13,6 +13,9 @@ var (
 	ErrUserDoesNotExist       = errors.New("user does not exist")
 )
 
+// The codes of every permission there is.
+var PermissionCodes = []string{"read", "issue", "write"}
+
 type Permissions []string
 
 func (p Permissions) Include(code string) bool {
@@ -67,7 +70,7 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int64, code int) error {
+func (m PermissionModel) AddForUser(tx *sql.Tx, userID int64, code int) error {
 	query := `
 		INSERT INTO users_permissions (user_id, permission_id)
 		VALUES ($1, $2)
@@ -76,7 +79,7 @@ func (m PermissionModel) AddForUser(userID int64, code int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, code)
+	_, err := tx.ExecContext(ctx, query, userID, code)
 
 	if err != nil {
 		switch {
@@ -93,7 +96,7 @@ func (m PermissionModel) AddForUser(userID int64, code int) error {
 	return err
 }
 
-func (m PermissionModel) RemoveForUser(userID int64, code int) error {
+func (m PermissionModel) RemoveForUser(tx *sql.Tx, userID int64, code int) error {
 	query := `
 		DELETE FROM users_permissions
 		WHERE user_id = $1 AND permission_id = $2	
@@ -102,6 +105,6 @@ func (m PermissionModel) RemoveForUser(userID int64, code int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, code)
+	_, err := tx.ExecContext(ctx, query, userID, code)
 	return err
 }
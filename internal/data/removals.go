@@ -4,15 +4,19 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 )
 
 type Removal struct {
 	ID        int64     `json:"id"`
 	ItemID    int64     `json:"item_id"`
+	ItemName  string    `json:"item_name,omitempty"`
 	Quantity  int32     `json:"quantity"`
 	Remarks   string    `json:"remarks"`
 	RemovedAt time.Time `json:"removed_at"`
+	// CreatedBy is the user who made the removal, nil once they are deleted
+	CreatedBy *int64 `json:"created_by"`
 }
 
 type RemovalModel struct {
@@ -21,30 +25,38 @@ type RemovalModel struct {
 
 func (m RemovalModel) InsertRemoval(tx *sql.Tx, removal *Removal) error {
 	query := `
-		INSERT INTO removals (item_id, quantity, remarks)
-		VALUES ($1, $2, $3)
+		INSERT INTO removals (item_id, quantity, remarks, created_by)
+		VALUES ($1, $2, $3, $4)
 		RETURNING id, removed_at`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []interface{}{removal.ItemID, removal.Quantity, removal.Remarks}
+	args := []interface{}{removal.ItemID, removal.Quantity, removal.Remarks, removal.CreatedBy}
 
 	return tx.QueryRowContext(ctx, query, args...).Scan(&removal.ID, &removal.RemovedAt)
 }
 
-func (m RemovalModel) GetRemovals(itemID int64, filters Filters) ([]*Removal, Metadata, error) {
-	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, item_id, quantity, remarks, removed_at
+// GetRemovals lists the removals matching filter, each with the name of its item.
+func (m RemovalModel) GetRemovals(filter LedgerFilter, filters Filters) ([]*Removal, Metadata, error) {
+	args := queryArgs{}
+
+	conditions := append(filter.conditions("removals", "removed_at", &args), filters.where(&args))
+	where := strings.Join(conditions, " AND ")
+
+	query := `
+		SELECT ` + filters.countColumn("removals INNER JOIN items ON items.id = removals.item_id", where) + `, removals.id, removals.item_id, items.name, removals.quantity, removals.remarks, removals.removed_at, removals.created_by
 		FROM removals
-		WHERE item_id = $1
-		ORDER BY %s %s
-		LIMIT %d OFFSET %d`, filters.sortColumn(), filters.sortDirection(), filters.limit(), filters.offset())
+		INNER JOIN items ON items.id = removals.item_id
+		WHERE ` + where + `
+		AND ` + filters.keyset("removals", &args) + `
+		ORDER BY ` + filters.orderBy("removals") + `
+		` + filters.limitOffset(&args)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, itemID)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		fmt.Printf("Error: %v", err)
 		return nil, Metadata{}, err
@@ -61,9 +73,11 @@ func (m RemovalModel) GetRemovals(itemID int64, filters Filters) ([]*Removal, Me
 			&totalRecords,
 			&removal.ID,
 			&removal.ItemID,
+			&removal.ItemName,
 			&removal.Quantity,
 			&removal.Remarks,
 			&removal.RemovedAt,
+			&removal.CreatedBy,
 		)
 		if err != nil {
 			fmt.Printf("Error: %v", err)
@@ -78,7 +92,15 @@ func (m RemovalModel) GetRemovals(itemID int64, filters Filters) ([]*Removal, Me
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	removals, metadata := paginate(removals, totalRecords, filters, removalSortValue)
 
 	return removals, metadata, nil
 }
+
+func removalSortValue(removal *Removal, column string) (interface{}, int64) {
+	if column == "removed_at" {
+		return removal.RemovedAt, removal.ID
+	}
+
+	return removal.ID, removal.ID
+}
@@ -0,0 +1,309 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrDuplicateSubscription = errors.New("duplicate subscription")
+
+// Subscription signs a user up for notifications about an item, or about
+// every item with a tag. Exactly one of ItemID and TagID is set.
+type Subscription struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	ItemID    *int64    `json:"item_id,omitempty"`
+	TagID     *int64    `json:"tag_id,omitempty"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Digest is the daily summary sent to one user about the items they are
+// subscribed to, and the loans of those items that are overdue.
+type Digest struct {
+	UserID   int64
+	UserName string
+	Email    string
+	Items    []DigestItem
+	Overdue  []DigestLoan
+}
+
+type DigestItem struct {
+	ID        int64
+	Name      string
+	Remaining int32
+	Consumed  int64
+	Low       bool
+}
+
+// DigestLoan is an issue of a subscribed item that is past its due date and
+// has not been returned.
+type DigestLoan struct {
+	IssueID  int64
+	ItemID   int64
+	ItemName string
+	Quantity int32
+	IssuedTo string
+	DueAt    time.Time
+}
+
+type SubscriptionModel struct {
+	DB *sql.DB
+}
+
+func (m SubscriptionModel) Insert(tx *sql.Tx, subscription *Subscription) error {
+	query := `
+		INSERT INTO subscriptions (user_id, item_id, tag_id)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{subscription.UserID, subscription.ItemID, subscription.TagID}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&subscription.ID, &subscription.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "subscriptions" violates foreign key constraint "subscriptions_item_id_fkey"`:
+			return ErrItemIdDoesNotExists
+		case err.Error() == `pq: insert or update on table "subscriptions" violates foreign key constraint "subscriptions_tag_id_fkey"`:
+			return ErrTagIdDoesNotExists
+		case err.Error() == `pq: duplicate key value violates unique constraint "subscriptions_user_item_key"`,
+			err.Error() == `pq: duplicate key value violates unique constraint "subscriptions_user_tag_key"`:
+			return ErrDuplicateSubscription
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllForUser lists the user's subscriptions, each with the name of its item
+// or tag.
+func (m SubscriptionModel) GetAllForUser(userID int64) ([]*Subscription, error) {
+	query := `
+		SELECT subscriptions.id, subscriptions.user_id, subscriptions.item_id, subscriptions.tag_id,
+			COALESCE(items.name, tags.name), subscriptions.created_at
+		FROM subscriptions
+		LEFT JOIN items ON items.id = subscriptions.item_id
+		LEFT JOIN tags ON tags.id = subscriptions.tag_id
+		WHERE subscriptions.user_id = $1
+		ORDER BY subscriptions.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []*Subscription{}
+
+	for rows.Next() {
+		var subscription Subscription
+
+		err := rows.Scan(
+			&subscription.ID,
+			&subscription.UserID,
+			&subscription.ItemID,
+			&subscription.TagID,
+			&subscription.Name,
+			&subscription.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, &subscription)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// Delete removes one of the user's subscriptions.
+func (m SubscriptionModel) Delete(tx *sql.Tx, id int64, userID int64) error {
+	if id < 1 {
+		return ErrNoRecord
+	}
+
+	query := `
+		DELETE FROM subscriptions
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// GetRecipientsForItem returns the users with an email address who are
+// subscribed to the item, directly or through one of its tags.
+func (m SubscriptionModel) GetRecipientsForItem(itemID int64) ([]*User, error) {
+	query := `
+		SELECT DISTINCT users.id, users.username, users.email
+		FROM users
+		INNER JOIN subscriptions ON subscriptions.user_id = users.id
+		WHERE users.email IS NOT NULL
+		AND (subscriptions.item_id = $1
+			OR subscriptions.tag_id IN (SELECT tag_id FROM item_tags WHERE item_id = $1))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(&user.ID, &user.UserName, &user.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// subscribedItemsCTE pairs every user with the items they are subscribed to,
+// directly or through a tag.
+const subscribedItemsCTE = `
+		subscribed AS (
+			SELECT DISTINCT subscriptions.user_id, COALESCE(subscriptions.item_id, item_tags.item_id) AS item_id
+			FROM subscriptions
+			LEFT JOIN item_tags ON item_tags.tag_id = subscriptions.tag_id
+			WHERE COALESCE(subscriptions.item_id, item_tags.item_id) IS NOT NULL
+		)`
+
+// GetDigests builds the daily digest of every user with an email address and
+// at least one subscribed item, with what was consumed over the last day and
+// the loans of those items that are overdue. Items at or below threshold are
+// marked low.
+func (m SubscriptionModel) GetDigests(threshold int) ([]*Digest, error) {
+	query := consumptionCTE + `,` + subscribedItemsCTE + `
+		SELECT users.id, users.username, users.email, items.id, items.name, items.remaining,
+			COALESCE(consumption.consumed, 0)
+		FROM subscribed
+		INNER JOIN users ON users.id = subscribed.user_id
+		INNER JOIN items ON items.id = subscribed.item_id
+		LEFT JOIN consumption ON consumption.item_id = items.id
+		WHERE users.email IS NOT NULL
+		ORDER BY users.id, items.name, items.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	digests := []*Digest{}
+	var digest *Digest
+
+	for rows.Next() {
+		var userID int64
+		var userName, email string
+		var item DigestItem
+
+		err := rows.Scan(&userID, &userName, &email, &item.ID, &item.Name, &item.Remaining, &item.Consumed)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Low = int(item.Remaining) <= threshold
+
+		if digest == nil || digest.UserID != userID {
+			digest = &Digest{UserID: userID, UserName: userName, Email: email}
+			digests = append(digests, digest)
+		}
+
+		digest.Items = append(digest.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = m.addOverdueLoans(ctx, digests)
+	if err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
+
+// addOverdueLoans adds the overdue loans of their subscribed items to the
+// digests, oldest due date first.
+func (m SubscriptionModel) addOverdueLoans(ctx context.Context, digests []*Digest) error {
+	query := `
+		WITH` + subscribedItemsCTE + `
+		SELECT subscribed.user_id, issues.id, items.id, items.name, issues.quantity, issues.issued_to, issues.due_at
+		FROM subscribed
+		INNER JOIN items ON items.id = subscribed.item_id
+		INNER JOIN issues ON issues.item_id = items.id
+		WHERE issues.due_at < NOW() AND issues.returned_at IS NULL
+		ORDER BY subscribed.user_id, issues.due_at, issues.id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byUser := make(map[int64]*Digest, len(digests))
+	for _, digest := range digests {
+		byUser[digest.UserID] = digest
+	}
+
+	for rows.Next() {
+		var userID int64
+		var loan DigestLoan
+
+		err := rows.Scan(&userID, &loan.IssueID, &loan.ItemID, &loan.ItemName, &loan.Quantity, &loan.IssuedTo, &loan.DueAt)
+		if err != nil {
+			return err
+		}
+
+		// Users without an email address have no digest
+		if digest, ok := byUser[userID]; ok {
+			digest.Overdue = append(digest.Overdue, loan)
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestGetDigestsOverdueLoans(t *testing.T) {
+	db := openTestDB(t)
+
+	user := insertTestUser(t, db)
+	if _, err := db.Exec("UPDATE users SET email = $1 WHERE id = $2", t.Name()+"@example.com", user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	item := insertTestItem(t, db, 10)
+	inTestTx(t, db, func(tx *sql.Tx) error {
+		return SubscriptionModel{DB: db}.Insert(tx, &Subscription{UserID: user.ID, ItemID: &item.ID})
+	})
+
+	// Inserted directly, as the API only accepts due dates in the future
+	insertLoan := func(issuedTo string, dueAt time.Time, returned bool) {
+		t.Helper()
+
+		var returnedAt *time.Time
+		if returned {
+			returnedAt = &dueAt
+		}
+
+		_, err := db.Exec(`
+			INSERT INTO issues (item_id, quantity, issued_to, due_at, returned_at)
+			VALUES ($1, 1, $2, $3, $4)`, item.ID, issuedTo, dueAt, returnedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	insertLoan("overdue", time.Now().Add(-48*time.Hour), false)
+	insertLoan("returned", time.Now().Add(-48*time.Hour), true)
+	insertLoan("not yet due", time.Now().Add(48*time.Hour), false)
+
+	digests, err := SubscriptionModel{DB: db}.GetDigests(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var digest *Digest
+	for _, d := range digests {
+		if d.UserID == user.ID {
+			digest = d
+		}
+	}
+	if digest == nil {
+		t.Fatal("no digest for the subscribed user")
+	}
+
+	if len(digest.Overdue) != 1 || digest.Overdue[0].IssuedTo != "overdue" {
+		t.Fatalf("got overdue loans %+v, want only the one issued to \"overdue\"", digest.Overdue)
+	}
+	if digest.Overdue[0].ItemID != item.ID {
+		t.Errorf("got item %d, want %d", digest.Overdue[0].ItemID, item.ID)
+	}
+}
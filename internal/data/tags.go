@@ -4,7 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
+
+	"test.com/internal/validator"
 )
 
 type Tag struct {
@@ -18,18 +22,124 @@ type ItemTag struct {
 	TagID  int32 `json:"tag_id"`
 }
 
+// TagFilter selects items by their tags. Tags are referenced by
+// (case-insensitive) name or by ID.
+type TagFilter struct {
+	Include []tagRef
+	Exclude []tagRef
+	// MatchAll requires items to have every included tag rather than any of them
+	MatchAll bool
+}
+
+type tagRef struct {
+	name string
+	id   int64
+	byID bool
+}
+
+// ParseTagFilter builds a TagFilter from the tag (name) and tag_id query
+// values, where a leading "-" excludes the tag.
+func ParseTagFilter(names []string, ids []string, mode string) TagFilter {
+	f := TagFilter{MatchAll: mode == "all"}
+
+	add := func(value string, ref func(string) tagRef) {
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "-") {
+			f.Exclude = append(f.Exclude, ref(strings.TrimSpace(strings.TrimPrefix(value, "-"))))
+		} else {
+			f.Include = append(f.Include, ref(value))
+		}
+	}
+
+	for _, name := range names {
+		add(name, func(s string) tagRef { return tagRef{name: s} })
+	}
+
+	for _, id := range ids {
+		add(id, func(s string) tagRef {
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				id = 0
+			}
+			return tagRef{id: id, byID: true}
+		})
+	}
+
+	return f
+}
+
+func ValidateTagFilter(v *validator.Validator, f TagFilter, mode string) {
+	v.Check(validator.In(mode, "all", "any"), "tag_mode", "must be all or any")
+	v.Check(len(f.Include)+len(f.Exclude) <= 20, "tag", "must not contain more than 20 tags")
+
+	for _, tags := range [][]tagRef{f.Include, f.Exclude} {
+		for _, tag := range tags {
+			if tag.byID {
+				v.Check(tag.id > 0, "tag_id", "must contain only positive integers")
+			} else {
+				v.Check(tag.name != "", "tag", "must not contain blank tags")
+			}
+		}
+	}
+}
+
+func tagCondition(tag tagRef, args *queryArgs) string {
+	if tag.byID {
+		return "tags.id = " + args.add(tag.id)
+	}
+
+	return "LOWER(tags.name) = LOWER(" + args.add(tag.name) + ")"
+}
+
+func tagExists(itemColumn string, condition string) string {
+	return `EXISTS (
+		SELECT 1 FROM item_tags
+		INNER JOIN tags ON tags.id = item_tags.tag_id
+		WHERE item_tags.item_id = ` + itemColumn + ` AND (` + condition + `))`
+}
+
+// conditions returns the SQL conditions matching itemColumn against the filter.
+func (f TagFilter) conditions(itemColumn string, args *queryArgs) []string {
+	conditions := []string{}
+
+	if len(f.Include) > 0 {
+		if f.MatchAll {
+			for _, tag := range f.Include {
+				conditions = append(conditions, tagExists(itemColumn, tagCondition(tag, args)))
+			}
+		} else {
+			matches := []string{}
+			for _, tag := range f.Include {
+				matches = append(matches, tagCondition(tag, args))
+			}
+			conditions = append(conditions, tagExists(itemColumn, strings.Join(matches, " OR ")))
+		}
+	}
+
+	if len(f.Exclude) > 0 {
+		excluded := []string{}
+		for _, tag := range f.Exclude {
+			excluded = append(excluded, tagCondition(tag, args))
+		}
+		conditions = append(conditions, "NOT "+tagExists(itemColumn, strings.Join(excluded, " OR ")))
+	}
+
+	return conditions
+}
+
 type TagModel struct {
 	DB *sql.DB
 }
 
 var (
 	ErrDuplicateName       = errors.New("duplicate name")
+	ErrDuplicateEmail      = errors.New("duplicate email")
 	ErrDuplicateItemTag    = errors.New("duplicate item tag")
 	ErrItemIdDoesNotExists = errors.New("item id does not exist")
 	ErrTagIdDoesNotExists  = errors.New("tag id does not exist")
 )
 
-func (m TagModel) InsertTag(tag *Tag) error {
+func (m TagModel) InsertTag(tx *sql.Tx, tag *Tag) error {
 	query := `
 		INSERT INTO tags (name)
 		VALUES ($1)
@@ -38,7 +148,7 @@ func (m TagModel) InsertTag(tag *Tag) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, tag.Name).Scan(&tag.ID)
+	err := tx.QueryRowContext(ctx, query, tag.Name).Scan(&tag.ID)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "tags_name_key"`:
@@ -48,23 +158,29 @@ func (m TagModel) InsertTag(tag *Tag) error {
 	return err
 }
 
-func (m TagModel) DeleteTag(tagId int) error {
+// DeleteTag deletes the tag and returns it as it was.
+func (m TagModel) DeleteTag(tx *sql.Tx, tagId int) (*Tag, error) {
 	query := `
 		DELETE FROM tags
 		WHERE id = $1
-		`
+		RETURNING id, name`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	res, err := m.DB.ExecContext(ctx, query, tagId)
-	affected, err := res.RowsAffected()
+	var tag Tag
+
+	err := tx.QueryRowContext(ctx, query, tagId).Scan(&tag.ID, &tag.Name)
 	if err != nil {
-		return err
-	} else if affected == 0 {
-		return ErrNoRecord
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
 	}
-	return err
+
+	return &tag, nil
 }
 
 func (m TagModel) GetTags() ([]*Tag, error) {
@@ -108,7 +224,7 @@ func (m TagModel) GetTags() ([]*Tag, error) {
 	return tags, nil
 }
 
-func (m TagModel) InsertItemTag(itemTag *ItemTag) error {
+func (m TagModel) InsertItemTag(tx *sql.Tx, itemTag *ItemTag) error {
 	query := `
 	INSERT INTO item_tags (item_id, tag_id)
 	VALUES ($1, $2)	
@@ -117,7 +233,7 @@ func (m TagModel) InsertItemTag(itemTag *ItemTag) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, itemTag.ItemID, itemTag.TagID).Scan(&itemTag.ID)
+	err := tx.QueryRowContext(ctx, query, itemTag.ItemID, itemTag.TagID).Scan(&itemTag.ID)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: insert or update on table "item_tags" violates foreign key constraint "item_tags_item_id_fkey"`:
@@ -131,14 +247,18 @@ func (m TagModel) InsertItemTag(itemTag *ItemTag) error {
 	return err
 }
 
-func (m TagModel) RemoveItemTag(itemId int, tagId int) error {
+func (m TagModel) RemoveItemTag(tx *sql.Tx, itemId int, tagId int) error {
 	query := `
 	DELETE FROM item_tags WHERE item_id = $1 AND tag_id = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	res, err := m.DB.ExecContext(ctx, query, itemId, tagId)
+	res, err := tx.ExecContext(ctx, query, itemId, tagId)
+	if err != nil {
+		return err
+	}
+
 	affected, err := res.RowsAffected()
 	if err != nil {
 		return err
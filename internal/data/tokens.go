@@ -6,18 +6,60 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"test.com/internal/validator"
 )
 
+// A token can only be used for its scope.
+const (
+	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that has already been
+// exchanged is presented again, which means it has probably been stolen.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
 type Token struct {
+	ID        int64     `json:"-"`
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+	CreatedAt time.Time `json:"-"`
+	IP        string    `json:"-"`
+	UserAgent string    `json:"-"`
+	// The access and refresh tokens of a session, and those that replace
+	// them, share a family
+	Family int64      `json:"-"`
+	Client string     `json:"-"`
+	UsedAt *time.Time `json:"-"`
+}
+
+// SessionTTL is how long the access and refresh tokens of a session last.
+type SessionTTL struct {
+	Access  time.Duration
+	Refresh time.Duration
 }
 
+// Session is a token family as shown to its user, who can revoke it.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
+}
+
+// Only this much of a session's user agent is kept.
+const maxUserAgentLength = 512
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
@@ -27,44 +69,306 @@ type TokenModel struct {
 	DB *sql.DB
 }
 
-func (m TokenModel) New(userID int64, ttl time.Duration) (*Token, error) {
-	token, err := generateToken(userID, ttl)
+func (m TokenModel) New(tx *sql.Tx, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = insertToken(tx, token)
 	return token, err
 }
 
-func (m TokenModel) Insert(token *Token) error {
+// NewSession creates the access and refresh tokens of a session, noting where
+// it was created from. A refreshed session passes its family, a new one 0.
+func (m TokenModel) NewSession(tx *sql.Tx, userID int64, family int64, client string, ttl SessionTTL, ip, userAgent string) (access, refresh *Token, err error) {
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	access, err = generateToken(userID, ttl.Access, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err = generateToken(userID, ttl.Refresh, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.Family = family
+		token.Client = client
+		token.IP = ip
+		token.UserAgent = userAgent
+
+		err = insertToken(tx, token)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		family = token.Family
+	}
+
+	return access, refresh, nil
+}
+
+// insertToken saves the token, in a new family of its own unless it has one.
+func insertToken(db interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, token *Token) error {
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope, ip, user_agent, family, client)
+	VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7::bigint, 0), nextval('token_families')), COALESCE(NULLIF($8, ''), 'default'))
+	RETURNING id, created_at, family, client`
+
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.Family, token.Client}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return db.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt, &token.Family, &token.Client)
+}
+
+// UseRefreshToken exchanges a refresh token, locking it for the rest of the
+// transaction. The token is marked used and the session's access token is
+// revoked, ready for the caller to issue new ones in the same family. If the
+// token was already used, the whole family is revoked and
+// ErrRefreshTokenReused returned, so a stolen token and any tokens issued for
+// it stop working.
+func (m TokenModel) UseRefreshToken(tx *sql.Tx, tokenPlaintext string) (*Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT id, user_id, expiry, family, client, used_at
+		FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+		FOR UPDATE`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token := Token{Hash: tokenHash[:], Scope: ScopeRefresh}
+
+	err := tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Expiry,
+		&token.Family,
+		&token.Client,
+		&token.UsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	if token.UsedAt != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1`, token.Family)
+		if err != nil {
+			return nil, err
+		}
+
+		return &token, ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = NOW() WHERE id = $1`, token.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family = $1 AND scope = $2`, token.Family, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// UsePasswordResetToken consumes a password reset token in the transaction,
+// returning the ID of its user. The user's other password reset tokens and
+// sessions are revoked with it, so the transaction should also set the new
+// password.
+func (m TokenModel) UsePasswordResetToken(tx *sql.Tx, tokenPlaintext string) (int64, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+		RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+
+	err := tx.QueryRowContext(ctx, query, tokenHash[:], ScopePasswordReset).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNoRecord
+		default:
+			return 0, err
+		}
+	}
+
+	query = `DELETE FROM tokens WHERE scope IN ($1, $2, $3) AND user_id = $4`
+
+	_, err = tx.ExecContext(ctx, query, ScopePasswordReset, ScopeAuthentication, ScopeRefresh, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Touch records that the token has been used. It is only written once a
+// minute, so that every request does not update the token.
+func (m TokenModel) Touch(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry)
-	VALUES ($1, $2, $3)`
+		UPDATE tokens
+		SET last_used_at = NOW()
+		WHERE hash = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:])
+	return err
+}
+
+// DeleteFamilyOf revokes the session the token belongs to, such as when its
+// user signs out.
+func (m TokenModel) DeleteFamilyOf(tx *sql.Tx, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		DELETE FROM tokens
+		WHERE family = (SELECT family FROM tokens WHERE hash = $1)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	_, err := tx.ExecContext(ctx, query, tokenHash[:])
 	return err
 }
 
-func (m TokenModel) DeleteAllForUser(userID int64) error {
-	query := `DELETE FROM tokens WHERE user_id = $2`
+// GetSessionsForUser lists the user's sessions that have not expired, newest
+// first, marking the one that currentToken belongs to. A session is created
+// when its first tokens were and has the IP address and user agent of its
+// latest ones.
+func (m TokenModel) GetSessionsForUser(userID int64, currentToken string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentToken))
+
+	query := `
+		SELECT family, MIN(created_at), MAX(last_used_at),
+			MAX(expiry) FILTER (WHERE used_at IS NULL),
+			(ARRAY_AGG(ip ORDER BY created_at DESC, id DESC))[1],
+			(ARRAY_AGG(user_agent ORDER BY created_at DESC, id DESC))[1],
+			BOOL_OR(hash = $3)
+		FROM tokens
+		WHERE user_id = $1 AND scope IN ($2, $4)
+		GROUP BY family
+		HAVING BOOL_OR(used_at IS NULL AND expiry > NOW())
+		ORDER BY MIN(created_at) DESC, family DESC`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID)
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication, currentHash[:], ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession revokes one of the user's sessions, given its family.
+func (m TokenModel) DeleteSession(tx *sql.Tx, family, userID int64) error {
+	if family < 1 {
+		return ErrNoRecord
+	}
+
+	query := `
+		DELETE FROM tokens
+		WHERE family = $1 AND user_id = $2 AND scope IN ($3, $4)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, family, userID, ScopeAuthentication, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// DeleteSessionsForUser revokes every session of the user.
+func (m TokenModel) DeleteSessionsForUser(tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM tokens WHERE scope IN ($1, $2) AND user_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, ScopeAuthentication, ScopeRefresh, userID)
+	return err
+}
+
+func (m TokenModel) DeleteScopeForUser(tx *sql.Tx, scope string, userID int64) error {
+	query := `DELETE FROM tokens WHERE scope = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, scope, userID)
 	return err
 }
 
-func generateToken(userId int64, ttl time.Duration) (*Token, error) {
+func generateToken(userId int64, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
 		UserID: userId,
 		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
 	}
 
 	randomBytes := make([]byte, 16)
@@ -80,3 +384,21 @@ func generateToken(userId int64, ttl time.Duration) (*Token, error) {
 
 	return token, nil
 }
+
+// DeleteExpired removes the tokens that have expired and returns how many were
+// removed.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
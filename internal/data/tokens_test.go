@@ -0,0 +1,50 @@
+package data
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func insertTestUser(t *testing.T, db *sql.DB) *User {
+	t.Helper()
+
+	m := UserModel{DB: db}
+	user := &User{UserName: t.Name(), Hash: "x"}
+
+	inTestTx(t, db, func(tx *sql.Tx) error { return m.Insert(tx, user) })
+
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", user.ID) })
+
+	return user
+}
+
+func TestUsePasswordResetTokenOnce(t *testing.T) {
+	db := openTestDB(t)
+	m := TokenModel{DB: db}
+
+	user := insertTestUser(t, db)
+
+	var token *Token
+	inTestTx(t, db, func(tx *sql.Tx) (err error) {
+		token, err = m.New(tx, user.ID, time.Hour, ScopePasswordReset)
+		return err
+	})
+
+	const workers = 10
+
+	used, invalid := runConcurrentTxs(t, db, workers, ErrNoRecord, func(tx *sql.Tx) error {
+		userID, err := m.UsePasswordResetToken(tx, token.Plaintext)
+		if err == nil && userID != user.ID {
+			t.Errorf("user ID = %d; want %d", userID, user.ID)
+		}
+		return err
+	})
+
+	if used != 1 {
+		t.Errorf("used = %d; want 1", used)
+	}
+	if want := workers - 1; invalid != want {
+		t.Errorf("invalid = %d; want %d", invalid, want)
+	}
+}
@@ -11,12 +11,16 @@ import (
 type User struct {
 	ID        int64     `json:"id"`
 	UserName  string    `json:"username"`
-	Password  string    `json:"password"`
+	Email     string    `json:"email"`
+	Password  string    `json:"-"`
 	Hash      string    `json:"-"`
 	IsAdmin   bool      `json:"is_admin"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Version   int       `json:"version"`
+	// Set when the request is made with an API key, which acts as itself with
+	// the key's permissions
+	APIKeyID *int64 `json:"-"`
 }
 
 type UserModel struct {
@@ -29,22 +33,24 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(tx *sql.Tx, user *User) error {
 	query := `
-		INSERT INTO users (username, hash, is_admin, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, version`
+		INSERT INTO users (username, email, hash, is_admin)
+		VALUES ($1, NULLIF($2, ''), $3, $4)
+		RETURNING id, created_at, updated_at, version`
 
-	args := []interface{}{user.UserName, user.Hash, user.IsAdmin, user.CreatedAt, user.UpdatedAt}
+	args := []interface{}{user.UserName, user.Email, user.Hash, user.IsAdmin}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Version)
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == "pq: duplicate key value violates unique constraint \"users_username_key\"":
 			return ErrDuplicateName
+		case err.Error() == "pq: duplicate key value violates unique constraint \"users_email_key\"":
+			return ErrDuplicateEmail
 		}
 		return err
 	}
@@ -54,7 +60,7 @@ func (m UserModel) Insert(user *User) error {
 
 func (m UserModel) GetUserByUserName(username string) (*User, error) {
 	query := `
-		SELECT id, username, hash, is_admin, created_at, updated_at, version
+		SELECT id, username, COALESCE(email, ''), hash, is_admin, created_at, updated_at, version
 		FROM users
 		WHERE username = $1`
 
@@ -66,6 +72,7 @@ func (m UserModel) GetUserByUserName(username string) (*User, error) {
 	err := m.DB.QueryRowContext(ctx, query, username).Scan(
 		&user.ID,
 		&user.UserName,
+		&user.Email,
 		&user.Hash,
 		&user.IsAdmin,
 		&user.CreatedAt,
@@ -84,15 +91,62 @@ func (m UserModel) GetUserByUserName(username string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Get(id int64) (*User, error) {
 	query := `
-    UPDATE users SET username = $1, hash = $2, updated_at = CURRENT_TIMESTAMP, version = version + 1
-    WHERE id = $3 AND version = $4
+		SELECT id, username, COALESCE(email, ''), hash, is_admin, created_at, updated_at, version
+		FROM users
+		WHERE id = $1`
+
+	return m.getOne(query, id)
+}
+
+func (m UserModel) GetByEmail(email string) (*User, error) {
+	query := `
+		SELECT id, username, COALESCE(email, ''), hash, is_admin, created_at, updated_at, version
+		FROM users
+		WHERE email = $1`
+
+	return m.getOne(query, email)
+}
+
+func (m UserModel) getOne(query string, arg interface{}) (*User, error) {
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(
+		&user.ID,
+		&user.UserName,
+		&user.Email,
+		&user.Hash,
+		&user.IsAdmin,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserModel) Update(tx *sql.Tx, user *User) error {
+	query := `
+    UPDATE users SET username = $1, email = NULLIF($2, ''), hash = $3, updated_at = CURRENT_TIMESTAMP, version = version + 1
+    WHERE id = $4 AND version = $5
     RETURNING version
   `
 
 	args := []interface{}{
 		user.UserName,
+		user.Email,
 		user.Hash,
 		user.ID,
 		user.Version,
@@ -101,11 +155,13 @@ func (m UserModel) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
 			return ErrDuplicateName
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
 		default:
@@ -115,17 +171,41 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(token string) (*User, error) {
+// SetHash replaces the user's password hash in the transaction.
+func (m UserModel) SetHash(tx *sql.Tx, userID int64, hash string) error {
+	query := `
+    UPDATE users SET hash = $1, updated_at = CURRENT_TIMESTAMP, version = version + 1
+    WHERE id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, hash, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (m UserModel) GetForToken(scope, token string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(token))
 
 	query := `
-	SELECT users.id, users.username, users.hash, users.is_admin, users.created_at, users.updated_at, users.version
+	SELECT users.id, users.username, COALESCE(users.email, ''), users.hash, users.is_admin, users.created_at, users.updated_at, users.version
 	FROM users
 	INNER JOIN tokens ON users.id = tokens.user_id
-	WHERE tokens.hash = $1 AND tokens.expiry > $2
+	WHERE tokens.hash = $1 AND tokens.scope = $2 AND tokens.expiry > $3
 	`
 
-	args := []interface{}{tokenHash[:], time.Now().UTC()}
+	args := []interface{}{tokenHash[:], scope, time.Now().UTC()}
 
 	var user User
 
@@ -135,6 +215,7 @@ func (m UserModel) GetForToken(token string) (*User, error) {
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
 		&user.UserName,
+		&user.Email,
 		&user.Hash,
 		&user.IsAdmin,
 		&user.CreatedAt,
@@ -156,7 +237,7 @@ func (m UserModel) GetForToken(token string) (*User, error) {
 }
 
 func (m UserModel) GetAllNonAdmin() ([]*User, error) {
-	query := `SELECT id, username, hash, is_admin, created_at, updated_at, version FROM users WHERE is_admin = false`
+	query := `SELECT id, username, COALESCE(email, ''), hash, is_admin, created_at, updated_at, version FROM users WHERE is_admin = false`
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -179,6 +260,7 @@ func (m UserModel) GetAllNonAdmin() ([]*User, error) {
 		err := rows.Scan(
 			&user.ID,
 			&user.UserName,
+			&user.Email,
 			&user.Hash,
 			&user.IsAdmin,
 			&user.CreatedAt,
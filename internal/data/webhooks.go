@@ -0,0 +1,361 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+	"test.com/internal/validator"
+)
+
+// The events a webhook can subscribe to.
+var WebhookEvents = []string{
+	"item.created",
+	"item.updated",
+	"item.deleted",
+	"issue.created",
+	"issue.returned",
+	"removal.created",
+	"addition.created",
+	"item.low_stock",
+}
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint that is sent the events it subscribes to. Secret
+// signs every delivery and is only shown when the webhook is created.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to one webhook.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      string          `json:"last_error"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	// URL and Secret are those of the webhook, filled in for sending
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	u, err := url.Parse(webhook.URL)
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least one event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", "unknown event "+event)
+	}
+}
+
+// Insert saves the webhook with a newly generated secret.
+func (m WebhookModel) Insert(tx *sql.Tx, webhook *Webhook) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	webhook.Secret = hex.EncodeToString(b)
+
+	query := `
+		INSERT INTO webhooks (url, secret, events)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{webhook.URL, webhook.Secret, pq.Array(webhook.Events)}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+func (m WebhookModel) GetAll() ([]*Webhook, error) {
+	query := `
+		SELECT id, url, events, created_at
+		FROM webhooks
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(&webhook.ID, &webhook.URL, pq.Array(&webhook.Events), &webhook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (m WebhookModel) Delete(tx *sql.Tx, id int64) error {
+	if id < 1 {
+		return ErrNoRecord
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Enqueue queues a delivery of the event to every webhook subscribed to it, as
+// part of tx.
+func (m WebhookModel) Enqueue(tx *sql.Tx, event string, payload []byte) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $1, $2
+		FROM webhooks
+		WHERE $1 = ANY(events)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, event, payload)
+	return err
+}
+
+// Replay queues the payload of an earlier delivery to be sent again, as a new
+// delivery.
+func (m WebhookModel) Replay(tx *sql.Tx, deliveryID int64) (*WebhookDelivery, error) {
+	if deliveryID < 1 {
+		return nil, ErrNoRecord
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT webhook_id, event, payload
+		FROM webhook_deliveries
+		WHERE id = $1
+		RETURNING ` + deliveryColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	delivery, err := scanDelivery(tx.QueryRowContext(ctx, query, deliveryID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRecord
+		default:
+			return nil, err
+		}
+	}
+
+	return delivery, nil
+}
+
+const deliveryColumns = `id, webhook_id, event, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+func scanDelivery(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var payload []byte
+
+	dest := []interface{}{
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.LastStatusCode,
+		&delivery.LastError,
+		&delivery.CreatedAt,
+		&delivery.DeliveredAt,
+	}
+
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = json.RawMessage(payload)
+
+	return &delivery, nil
+}
+
+// GetDeliveries lists the deliveries made to a webhook, newest first unless
+// sorted otherwise.
+func (m WebhookModel) GetDeliveries(webhookID int64, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	args := queryArgs{}
+
+	where := "webhook_deliveries.webhook_id = " + args.add(webhookID)
+
+	query := `
+		SELECT ` + deliveryColumns + `, ` + filters.countColumn("webhook_deliveries", where) + `
+		FROM webhook_deliveries
+		WHERE ` + where + `
+		AND ` + filters.keyset("webhook_deliveries", &args) + `
+		ORDER BY ` + filters.orderBy("webhook_deliveries") + `
+		` + filters.limitOffset(&args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	totalRecords := 0
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	deliveries, metadata := paginate(deliveries, totalRecords, filters, func(delivery *WebhookDelivery, column string) (interface{}, int64) {
+		return delivery.ID, delivery.ID
+	})
+
+	return deliveries, metadata, nil
+}
+
+// ClaimDue takes up to limit pending deliveries that are due and counts an
+// attempt for each. They are leased for lease, so that another worker only
+// picks them up again if this one never records the outcome.
+func (m WebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhooks
+		WHERE webhooks.id = webhook_deliveries.webhook_id
+		AND webhook_deliveries.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING webhook_deliveries.id, webhook_deliveries.webhook_id, webhook_deliveries.event,
+			webhook_deliveries.payload, webhook_deliveries.status, webhook_deliveries.attempts,
+			webhook_deliveries.next_attempt_at, webhook_deliveries.last_status_code,
+			webhook_deliveries.last_error, webhook_deliveries.created_at,
+			webhook_deliveries.delivered_at, webhooks.url, webhooks.secret`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var url, secret string
+
+		delivery, err := scanDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.URL = url
+		delivery.Secret = secret
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordAttempt saves the outcome of sending a delivery: its status, when to
+// try again if it is still pending, and the response code or error.
+func (m WebhookModel) RecordAttempt(delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, next_attempt_at = $2, last_status_code = $3, last_error = $4, delivered_at = $5
+		WHERE id = $6`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		delivery.Status,
+		delivery.NextAttemptAt,
+		delivery.LastStatusCode,
+		delivery.LastError,
+		delivery.DeliveredAt,
+		delivery.ID,
+	}
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,202 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	textTemplate "text/template"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends templated emails through an SMTP server. Authentication is only
+// attempted when a username is set, so a local catch-all server works as is.
+type Mailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	sender   string
+	timeout  time.Duration
+}
+
+func New(host string, port int, username, password, sender string) Mailer {
+	return Mailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		sender:   sender,
+		timeout:  10 * time.Second,
+	}
+}
+
+// Message is an email rendered from a template, ready to be sent.
+type Message struct {
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Send renders the subject, plainBody and htmlBody templates defined in
+// templateFile with data and sends the result to recipient.
+func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := Render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	return m.SendMessage(recipient, msg)
+}
+
+// SendMessage sends a message rendered earlier to recipient.
+func (m Mailer) SendMessage(recipient string, msg *Message) error {
+	body, err := m.message(recipient, msg.Subject, msg.PlainBody, msg.HTMLBody)
+	if err != nil {
+		return err
+	}
+
+	return m.deliver(recipient, body)
+}
+
+// Render executes the subject, plainBody and htmlBody templates of
+// templateFile with data.
+func Render(templateFile string, data interface{}) (*Message, error) {
+	text, err := textTemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = text.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = text.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = html.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}
+
+	return msg, nil
+}
+
+// message builds a multipart/alternative message with plain and HTML bodies.
+func (m Mailer) message(recipient, subject, plainBody, htmlBody string) ([]byte, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	boundary := hex.EncodeToString(b)
+
+	var msg bytes.Buffer
+
+	fmt.Fprintf(&msg, "From: %s\r\n", m.sender)
+	fmt.Fprintf(&msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", plainBody},
+		{"text/html", htmlBody},
+	} {
+		fmt.Fprintf(&msg, "--%s\r\n", boundary)
+		fmt.Fprintf(&msg, "Content-Type: %s; charset=utf-8\r\n\r\n", part.contentType)
+		msg.WriteString(strings.ReplaceAll(part.body, "\n", "\r\n"))
+		msg.WriteString("\r\n")
+	}
+
+	fmt.Fprintf(&msg, "--%s--\r\n", boundary)
+
+	return msg.Bytes(), nil
+}
+
+func (m Mailer) deliver(recipient string, msg []byte) error {
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+
+	conn, err := net.DialTimeout("tcp", addr, m.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(m.timeout))
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: m.host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.username != "" {
+		err = client.Auth(smtp.PlainAuth("", m.username, m.password, m.host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(m.sender)
+	if err != nil {
+		return err
+	}
+
+	err = client.Rcpt(recipient)
+	if err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"test.com/internal/data"
+)
+
+func TestRenderDigestOverdueLoans(t *testing.T) {
+	digest := &data.Digest{
+		UserName: "alice",
+		Items:    []data.DigestItem{{ID: 1, Name: "Drill", Remaining: 2}},
+		Overdue: []data.DigestLoan{
+			{IssueID: 7, ItemID: 1, ItemName: "Drill", Quantity: 1, IssuedTo: "bob", DueAt: time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	msg, err := Render("digest.tmpl", digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{msg.PlainBody, msg.HTMLBody} {
+		if !strings.Contains(body, "overdue") || !strings.Contains(body, "bob") || !strings.Contains(body, "2026-03-04") {
+			t.Errorf("overdue loan missing from the digest:\n%s", body)
+		}
+	}
+
+	digest.Overdue = nil
+
+	msg, err = Render("digest.tmpl", digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(msg.PlainBody, "overdue") {
+		t.Errorf("digest without overdue loans mentions them:\n%s", msg.PlainBody)
+	}
+}
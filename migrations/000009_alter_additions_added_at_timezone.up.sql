@@ -0,0 +1,3 @@
+ALTER TABLE additions
+    ALTER COLUMN added_at TYPE TIMESTAMP WITH TIME ZONE USING added_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN added_at SET DEFAULT NOW();
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS item_tags_tag_id_idx;
+DROP INDEX IF EXISTS tags_name_search_idx;
+DROP INDEX IF EXISTS items_name_trgm_idx;
+DROP INDEX IF EXISTS items_search_idx;
+ALTER TABLE items DROP COLUMN IF EXISTS search;
@@ -0,0 +1,12 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE items ADD COLUMN IF NOT EXISTS search tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', name), 'A') ||
+        setweight(to_tsvector('english', COALESCE(remarks, '')), 'B')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS items_search_idx ON items USING GIN (search);
+CREATE INDEX IF NOT EXISTS items_name_trgm_idx ON items USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS tags_name_search_idx ON tags USING GIN (to_tsvector('english', name));
+CREATE INDEX IF NOT EXISTS item_tags_tag_id_idx ON item_tags(tag_id);
@@ -0,0 +1 @@
+ALTER TABLE items DROP CONSTRAINT IF EXISTS items_remaining_non_negative;
@@ -0,0 +1,4 @@
+-- Items edited before this check could have a negative remaining, so the
+-- constraint is only checked for new rows here and validated in 000031, once
+-- those items are clamped
+ALTER TABLE items ADD CONSTRAINT items_remaining_non_negative CHECK (remaining >= 0) NOT VALID;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS adjustments (
+    id SERIAL PRIMARY KEY,
+    item_id INT NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    quantity INT NOT NULL,
+    remarks TEXT NOT NULL,
+    adjusted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX adjustments_item_id_idx ON adjustments(item_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS item_history (
+    id BIGSERIAL PRIMARY KEY,
+    -- No foreign key so that the history outlives a deleted item
+    item_id BIGINT NOT NULL,
+    user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    changes JSONB NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX item_history_item_id_idx ON item_history(item_id, id);
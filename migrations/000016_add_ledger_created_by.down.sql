@@ -0,0 +1,3 @@
+ALTER TABLE issues DROP COLUMN IF EXISTS created_by;
+ALTER TABLE removals DROP COLUMN IF EXISTS created_by;
+ALTER TABLE additions DROP COLUMN IF EXISTS created_by;
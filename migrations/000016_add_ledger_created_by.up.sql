@@ -0,0 +1,7 @@
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS created_by BIGINT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE removals ADD COLUMN IF NOT EXISTS created_by BIGINT REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE additions ADD COLUMN IF NOT EXISTS created_by BIGINT REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS issues_created_by_idx ON issues(created_by);
+CREATE INDEX IF NOT EXISTS removals_created_by_idx ON removals(created_by);
+CREATE INDEX IF NOT EXISTS additions_created_by_idx ON additions(created_by);
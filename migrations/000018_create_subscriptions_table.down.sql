@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS subscriptions;
+ALTER TABLE users DROP COLUMN IF EXISTS email;
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT UNIQUE;
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    item_id INT REFERENCES items(id) ON DELETE CASCADE,
+    tag_id INT REFERENCES tags(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT subscriptions_item_or_tag CHECK ((item_id IS NULL) <> (tag_id IS NULL)),
+    CONSTRAINT subscriptions_user_item_key UNIQUE (user_id, item_id),
+    CONSTRAINT subscriptions_user_tag_key UNIQUE (user_id, tag_id)
+);
+
+CREATE INDEX subscriptions_item_id_idx ON subscriptions(item_id);
+CREATE INDEX subscriptions_tag_id_idx ON subscriptions(tag_id);
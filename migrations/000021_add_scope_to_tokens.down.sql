@@ -0,0 +1,2 @@
+DELETE FROM tokens WHERE scope <> 'authentication';
+ALTER TABLE tokens DROP COLUMN IF EXISTS scope;
@@ -0,0 +1,7 @@
+CREATE SEQUENCE IF NOT EXISTS token_families;
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS family BIGINT NOT NULL DEFAULT nextval('token_families');
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS client TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS used_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON tokens(family);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    hash BYTEA NOT NULL UNIQUE,
+    permissions TEXT[] NOT NULL,
+    -- The key acts on behalf of the admin who created it
+    created_by BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS api_key_id BIGINT;
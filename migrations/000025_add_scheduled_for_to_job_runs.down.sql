@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS job_runs_job_scheduled_for_idx;
+
+ALTER TABLE job_runs DROP COLUMN IF EXISTS scheduled_for;
@@ -0,0 +1,3 @@
+ALTER TABLE job_runs ADD COLUMN IF NOT EXISTS scheduled_for TIMESTAMP WITH TIME ZONE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS job_runs_job_scheduled_for_idx ON job_runs(job, scheduled_for);
@@ -0,0 +1 @@
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,4 @@
+DELETE FROM api_keys WHERE created_by IS NULL;
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_created_by_fkey;
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_created_by_fkey FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE api_keys ALTER COLUMN created_by SET NOT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS token bytea NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys ALTER COLUMN token DROP DEFAULT;
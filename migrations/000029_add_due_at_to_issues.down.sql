@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS issues_open_loans_idx;
+
+ALTER TABLE issues DROP COLUMN IF EXISTS returned_at;
+ALTER TABLE issues DROP COLUMN IF EXISTS due_at;
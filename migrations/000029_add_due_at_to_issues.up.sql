@@ -0,0 +1,4 @@
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS due_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS returned_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS issues_open_loans_idx ON issues(due_at) WHERE due_at IS NOT NULL AND returned_at IS NULL;
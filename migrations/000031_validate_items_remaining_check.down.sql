@@ -0,0 +1,2 @@
+-- The clamped items and their adjustments are kept. The constraint itself is
+-- dropped by 000012's down migration.
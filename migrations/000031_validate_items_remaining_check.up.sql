@@ -0,0 +1,14 @@
+-- Items left with a negative remaining are set to zero, and the stock added
+-- to get there is recorded as an adjustment so the ledger still adds up
+WITH negative AS (
+    SELECT id, remaining FROM items WHERE remaining < 0 FOR UPDATE
+), clamped AS (
+    UPDATE items SET remaining = 0, version = version + 1
+    FROM negative
+    WHERE items.id = negative.id
+)
+INSERT INTO adjustments (item_id, quantity, remarks)
+SELECT id, -remaining, 'remaining was below zero and was set to zero'
+FROM negative;
+
+ALTER TABLE items VALIDATE CONSTRAINT items_remaining_non_negative;
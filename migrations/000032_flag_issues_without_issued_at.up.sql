@@ -0,0 +1,6 @@
+-- Issues made before issued_at was set by the database were saved with Go's
+-- zero time, and when they really happened is not known. They are flagged so
+-- that stock as of a past time and forecasts can leave them out.
+ALTER TABLE issues ADD COLUMN IF NOT EXISTS issued_at_unknown BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE issues SET issued_at_unknown = TRUE WHERE issued_at < '0002-01-01';
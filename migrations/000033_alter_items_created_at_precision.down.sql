@@ -0,0 +1 @@
+ALTER TABLE items ALTER COLUMN created_at TYPE TIMESTAMP(0) WITH TIME ZONE;
@@ -0,0 +1,3 @@
+-- created_at was rounded to the second, which could put an item created just
+-- before a time after it
+ALTER TABLE items ALTER COLUMN created_at TYPE TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS outbox_pruned;
+DROP TABLE IF EXISTS outbox_positions;
@@ -0,0 +1,26 @@
+-- Outbox IDs are taken when an event is saved, not when its transaction
+-- commits, so a slow transaction can commit an event below one that has
+-- already been read. Events are given a position once they have committed,
+-- one batch at a time, so that reading on from a position cannot miss any.
+CREATE TABLE IF NOT EXISTS outbox_positions (
+    position BIGSERIAL PRIMARY KEY,
+    event_id BIGINT NOT NULL UNIQUE
+);
+
+-- Existing events keep their ID as their position, so that streams resume
+-- where they were
+INSERT INTO outbox_positions (position, event_id)
+SELECT id, id FROM outbox ORDER BY id;
+
+SELECT setval('outbox_positions_position_seq', COALESCE((SELECT MAX(id) FROM outbox), 0) + 1, false);
+
+-- The newest position whose event has been removed by the outbox cleanup.
+-- A stream resuming from before it has missed events. The cleanup removes
+-- dispatched events, so those removed so far are taken to be the ones below
+-- the oldest dispatched event left.
+CREATE TABLE IF NOT EXISTS outbox_pruned (
+    pruned_through BIGINT NOT NULL
+);
+
+INSERT INTO outbox_pruned (pruned_through)
+SELECT COALESCE((SELECT MIN(id) - 1 FROM outbox WHERE status = 'dispatched'), (SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM outbox_id_seq));
@@ -0,0 +1,3 @@
+-- request_id is always generated by the server. The ID a client sent with its
+-- request is kept apart, as it cannot be trusted.
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS client_request_id TEXT NOT NULL DEFAULT '';
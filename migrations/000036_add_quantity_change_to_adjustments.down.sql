@@ -0,0 +1 @@
+ALTER TABLE adjustments DROP COLUMN IF EXISTS quantity_change;
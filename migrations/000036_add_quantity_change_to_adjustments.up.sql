@@ -0,0 +1,3 @@
+-- Edits to an item's quantity are recorded in the ledger alongside those to
+-- its remaining stock
+ALTER TABLE adjustments ADD COLUMN IF NOT EXISTS quantity_change INT NOT NULL DEFAULT 0;